@@ -0,0 +1,72 @@
+// Copyright 2017-2021 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package cicd
+
+import (
+	"fmt"
+
+	"github.com/AtlantPlatform/terraform-provider-cicd/internal/pipelinesapi"
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/helper/validation"
+)
+
+// withApprovals adds approval settings to the schema of the pipeline resource
+func withApprovals(s map[string]*schema.Schema) map[string]*schema.Schema {
+	s["approvals_required"] = &schema.Schema{
+		Type:         schema.TypeInt,
+		Optional:     true,
+		Default:      0,
+		ValidateFunc: validation.IntAtLeast(0),
+		Description:  "Number of approvals required for the pipeline to be finished",
+	}
+	s["approvers"] = &schema.Schema{
+		Type:     schema.TypeList,
+		Optional: true,
+		Elem: &schema.Schema{
+			Type: schema.TypeString,
+		},
+		Description: "list of approvers",
+	}
+	return s
+}
+
+// validateApprovals is CustomizeDiff of pipeline resources:
+// there should be enough approvers to collect required number of approvals.
+// SDK reads unknown list as empty one, so empty approvers are left to checkApprovals on apply
+func validateApprovals(d *schema.ResourceDiff, meta interface{}) error {
+	if !d.NewValueKnown("approvals_required") || !d.NewValueKnown("approvers") {
+		return nil
+	}
+	approvers := d.Get("approvers").([]interface{})
+	if len(approvers) == 0 {
+		return nil
+	}
+	return approvalsError(d.Get("approvals_required").(int), len(approvers))
+}
+
+// checkApprovals validates approval settings with values known on apply
+func checkApprovals(d *schema.ResourceData) error {
+	return approvalsError(SafeNum(d, "approvals_required"), len(SafeStringList(d, "approvers")))
+}
+
+func approvalsError(required, approvers int) error {
+	if required > approvers {
+		return fmt.Errorf("approvals_required (%d) exceeds number of approvers (%d)",
+			required, approvers)
+	}
+	return nil
+}
+
+func approvalsPayload(d *schema.ResourceData) pipelinesapi.Approvals {
+	return pipelinesapi.Approvals{
+		ApprovalsRequired: SafeNum(d, "approvals_required"),
+		Approvers:         SafeStringList(d, "approvers"),
+	}
+}
+
+func setApprovals(d *schema.ResourceData, a pipelinesapi.Approvals) {
+	d.Set("approvals_required", a.ApprovalsRequired)
+	d.Set("approvers", a.Approvers)
+}
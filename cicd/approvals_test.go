@@ -0,0 +1,107 @@
+// Copyright 2017-2021 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package cicd
+
+import (
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/terraform"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// unknown marks value of the attribute not known until apply
+const unknown = "(known after apply)"
+
+// testConfig builds resource configuration the way terraform passes it to the provider,
+// with unknown values where test config has unknown
+func testConfig(res *schema.Resource, raw map[string]interface{}) *terraform.ResourceConfig {
+	block := res.CoreConfigSchema()
+	vals := map[string]cty.Value{}
+	for name, attr := range block.Attributes {
+		switch v := raw[name].(type) {
+		case nil:
+			vals[name] = cty.NullVal(attr.Type)
+		case int:
+			vals[name] = cty.NumberIntVal(int64(v))
+		case []interface{}:
+			list := []cty.Value{}
+			for _, item := range v {
+				list = append(list, cty.StringVal(item.(string)))
+			}
+			vals[name] = cty.ListVal(list)
+		case string:
+			if v == unknown {
+				vals[name] = cty.UnknownVal(attr.Type)
+			} else {
+				vals[name] = cty.StringVal(v)
+			}
+		}
+	}
+	return terraform.NewResourceConfigShimmed(cty.ObjectVal(vals), block)
+}
+
+func TestValidateApprovals(t *testing.T) {
+	cases := []struct {
+		name   string
+		config map[string]interface{}
+		fails  bool
+	}{
+		{"no approvals", map[string]interface{}{}, false},
+		{"equal", map[string]interface{}{"approvals_required": 2, "approvers": []interface{}{"alice", "bob"}}, false},
+		{"fewer", map[string]interface{}{"approvals_required": 1, "approvers": []interface{}{"alice", "bob"}}, false},
+		{"exceeds", map[string]interface{}{"approvals_required": 3, "approvers": []interface{}{"alice", "bob"}}, true},
+		// unknown approvers look empty to CustomizeDiff, so it is checked on apply
+		{"no approvers", map[string]interface{}{"approvals_required": 1}, false},
+		{"unknown approvers", map[string]interface{}{"approvals_required": 3, "approvers": unknown}, false},
+		{"unknown required", map[string]interface{}{"approvals_required": unknown, "approvers": []interface{}{"alice"}}, false},
+	}
+	resources := map[string]*schema.Resource{
+		"helm":      resourcePipelineHelm(),
+		"terraform": resourcePipelineTerraform(),
+		"script":    resourcePipelineScript(),
+	}
+	required := map[string]map[string]interface{}{
+		"helm":      {"archive": "helm/web.zip", "release": "web", "origin": "git@example.com:web.git"},
+		"terraform": {"archive": "plans/web.zip"},
+		"script":    {"exec": "make"},
+	}
+	for kind, res := range resources {
+		for _, c := range cases {
+			raw := map[string]interface{}{}
+			for k, v := range required[kind] {
+				raw[k] = v
+			}
+			for k, v := range c.config {
+				raw[k] = v
+			}
+			_, err := res.Diff(nil, testConfig(res, raw), nil)
+			if c.fails {
+				require.Error(t, err, "%s: %s", kind, c.name)
+				require.Contains(t, err.Error(), "exceeds number of approvers")
+			} else {
+				require.NoError(t, err, "%s: %s", kind, c.name)
+			}
+		}
+	}
+}
+
+func TestCheckApprovals(t *testing.T) {
+	r := require.New(t)
+	srv := newPipelinesServer(t)
+	res := resourcePipelineScript()
+	d := schema.TestResourceDataRaw(t, res.Schema, map[string]interface{}{
+		"exec":               "make",
+		"approvals_required": 1,
+	})
+	err := res.Create(d, srv.config())
+	r.Error(err)
+	r.Contains(err.Error(), "approvals_required (1) exceeds number of approvers (0)")
+	r.Empty(srv.activated)
+
+	r.NoError(d.Set("approvers", []interface{}{"alice"}))
+	r.NoError(res.Create(d, srv.config()))
+}
@@ -5,26 +5,100 @@
 package cicd
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
 	"os"
+	"time"
 
+	"github.com/AtlantPlatform/terraform-provider-cicd/internal/pipelinesapi"
 	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/helper/validation"
 	"github.com/hashicorp/terraform-plugin-sdk/terraform"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 )
 
 // Provider creates the Docker provider
 func Provider() terraform.ResourceProvider {
-	return &schema.Provider{
+	p := &schema.Provider{
 		Schema: map[string]*schema.Schema{
 			"api_root": {
 				Type:        schema.TypeString,
 				Required:    true,
 				Description: "Pipelines API root",
 			},
+			"api_token": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Sensitive:   true,
+				DefaultFunc: schema.EnvDefaultFunc("CICD_API_TOKEN", ""),
+				Description: "Bearer token for pipelines API authentication (takes precedence over basic auth)",
+			},
+			"api_username": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				RequiredWith: []string{"api_password"},
+				Description:  "Username for pipelines API basic authentication",
+			},
+			"api_password": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Sensitive:    true,
+				RequiredWith: []string{"api_username"},
+				Description:  "Password for pipelines API basic authentication",
+			},
+			"client_cert_path": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				RequiredWith: []string{"client_key_path"},
+				Description:  "Location of PEM client certificate for pipelines API mutual TLS",
+			},
+			"client_key_path": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				RequiredWith: []string{"client_cert_path"},
+				Description:  "Location of PEM client private key for pipelines API mutual TLS",
+			},
+			"ca_bundle_path": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Location of PEM bundle of CA certificates to verify pipelines API server",
+			},
+			"insecure_skip_verify": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "Skip verification of pipelines API server certificate (local development only)",
+			},
+			"request_timeout": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      60,
+				ValidateFunc: validation.IntAtLeast(0),
+				Description:  "Timeout of pipelines API request in seconds (0 means no timeout)",
+			},
+			"max_retries": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      3,
+				ValidateFunc: validation.IntAtLeast(0),
+				Description:  "Number of retries of pipelines API request on 429/5xx responses and network failures",
+			},
+			"proxy_url": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ValidateFunc: validation.IsURLWithScheme([]string{"http", "https", "socks5"}),
+				Description:  "Proxy for pipelines API requests. HTTP(S)_PROXY environment is used if not specified",
+			},
 			"kubernetes_config_path": {
 				Type:        schema.TypeString,
 				Optional:    true,
@@ -43,6 +117,32 @@ func Provider() terraform.ResourceProvider {
 				DefaultFunc: schema.EnvDefaultFunc("AWS_REGION", "eu-central-1"),
 				Description: "Name of AWS profile to access S3 configuration bucket (put helm charts)",
 			},
+			"aws_access_key": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				RequiredWith: []string{"aws_secret_key"},
+				Description:  "Static access key for S3 (takes precedence over aws_profile)",
+			},
+			"aws_secret_key": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Sensitive:    true,
+				RequiredWith: []string{"aws_access_key"},
+				Description:  "Static secret key for S3 (takes precedence over aws_profile)",
+			},
+			"s3_endpoint": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				DefaultFunc:  schema.EnvDefaultFunc("AWS_S3_ENDPOINT", ""),
+				ValidateFunc: validateOptionalURL,
+				Description:  "Custom endpoint of S3-compatible storage (MinIO, Ceph)",
+			},
+			"s3_force_path_style": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "Use path-style addressing of S3 bucket (usually required by MinIO)",
+			},
 		},
 		ResourcesMap: map[string]*schema.Resource{
 			"cicd_helm_chart":         resourceHelmChart(),
@@ -51,11 +151,14 @@ func Provider() terraform.ResourceProvider {
 			"cicd_pipeline_script":    resourcePipelineScript(),
 		},
 		DataSourcesMap: map[string]*schema.Resource{},
-		ConfigureFunc:  providerConfigure,
 	}
+	p.ConfigureFunc = func(d *schema.ResourceData) (interface{}, error) {
+		return providerConfigure(p.StopContext(), d)
+	}
+	return p
 }
 
-func providerConfigure(d *schema.ResourceData) (interface{}, error) {
+func providerConfigure(ctx context.Context, d *schema.ResourceData) (interface{}, error) {
 	root := d.Get("api_root").(string)
 	if root == "" {
 		return nil, errors.New("api_root is not provided")
@@ -70,30 +173,112 @@ func providerConfigure(d *schema.ResourceData) (interface{}, error) {
 	profile := d.Get("aws_profile").(string)
 	region := d.Get("aws_region").(string)
 
+	awsConfig := aws.Config{
+		Region:           aws.String(region),
+		S3ForcePathStyle: aws.Bool(d.Get("s3_force_path_style").(bool)),
+	}
+	if endpoint := d.Get("s3_endpoint").(string); endpoint != "" {
+		awsConfig.Endpoint = aws.String(endpoint)
+	}
+	if accessKey := d.Get("aws_access_key").(string); accessKey != "" {
+		awsConfig.Credentials = credentials.NewStaticCredentials(
+			accessKey, d.Get("aws_secret_key").(string), "")
+	}
+
 	var sess *session.Session
 	var err error
 	sess, err = session.NewSessionWithOptions(session.Options{
 		// Specify profile to load for the session's config
 		Profile: profile,
-		// Provide SDK Config options, such as Region.
-		Config: aws.Config{
-			Region: aws.String(region),
-		},
+		// Provide SDK Config options, such as Region and Endpoint.
+		Config: awsConfig,
 		// Force enable Shared Config support
 		SharedConfigState: session.SharedConfigEnable,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("Error creating AWS session: %s", err.Error())
 	}
-	// validating that we can obtain credentials from aws profile
+	// validating that we can obtain credentials from aws profile.
+	// charts could be published without AWS (local or http storage),
+	// so s3 storage will report the failure on its usage
 	if _, errCred := sess.Config.Credentials.Get(); errCred != nil {
-		return nil, fmt.Errorf("Error checking AWS profile: %s", errCred.Error())
+		log.Printf("[WARN] Error checking AWS profile: %s", errCred.Error())
+	}
+
+	httpClient, err := newHTTPClient(d)
+	if err != nil {
+		return nil, err
+	}
+	api := pipelinesapi.New(root, httpClient)
+	api.Credentials = pipelinesapi.Credentials{
+		Token:    d.Get("api_token").(string),
+		Username: d.Get("api_username").(string),
+		Password: d.Get("api_password").(string),
 	}
+	api.MaxRetries = d.Get("max_retries").(int)
 	return &providerConfig{
 		APIRoot:    root,
 		Kubeconfig: kubeconfig,
 		AwsProfile: profile,
 		AwsRegion:  region,
 		Session:    sess,
+
+		HTTPClient:         httpClient,
+		StorageHTTPClient:  &http.Client{Transport: http.DefaultTransport.(*http.Transport).Clone()},
+		RegistryHTTPClient: &http.Client{Transport: http.DefaultTransport.(*http.Transport).Clone()},
+		API:                api,
+		StopContext:        ctx,
+	}, nil
+}
+
+// validateOptionalURL accepts empty value, which environment default gives when not set
+func validateOptionalURL(v interface{}, k string) ([]string, []error) {
+	if v.(string) == "" {
+		return nil, nil
+	}
+	return validation.IsURLWithHTTPorHTTPS(v, k)
+}
+
+// newHTTPClient creates HTTP client for pipelines API
+func newHTTPClient(d *schema.ResourceData) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	tlsConfig := &tls.Config{}
+
+	certPath := d.Get("client_cert_path").(string)
+	keyPath := d.Get("client_key_path").(string)
+	if certPath != "" {
+		cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+		if err != nil {
+			return nil, fmt.Errorf("Error loading client certificate: %s", err.Error())
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if caPath := d.Get("ca_bundle_path").(string); caPath != "" {
+		pem, err := ioutil.ReadFile(caPath)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading CA bundle: %s", err.Error())
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("Error reading CA bundle: no certificates found in %s", caPath)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if d.Get("insecure_skip_verify").(bool) {
+		log.Println("[WARN] pipelines API server certificate will not be verified")
+		tlsConfig.InsecureSkipVerify = true
+	}
+	transport.TLSClientConfig = tlsConfig
+
+	if proxy := d.Get("proxy_url").(string); proxy != "" {
+		proxyURL, err := url.Parse(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing proxy_url: %s", err.Error())
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	return &http.Client{
+		Transport: transport,
+		Timeout:   time.Duration(d.Get("request_timeout").(int)) * time.Second,
 	}, nil
 }
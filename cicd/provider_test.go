@@ -0,0 +1,190 @@
+// Copyright 2017-2021 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package cicd
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AtlantPlatform/terraform-provider-cicd/internal/storage"
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/terraform"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProvider(t *testing.T) {
+	if err := Provider().(*schema.Provider).InternalValidate(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestNewHTTPClient(t *testing.T) {
+	r := require.New(t)
+	provider := Provider().(*schema.Provider)
+	tlsServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer tlsServer.Close()
+
+	// server certificate is not trusted without CA bundle
+	cli, err := newHTTPClient(schema.TestResourceDataRaw(t, provider.Schema, map[string]interface{}{}))
+	r.NoError(err)
+	r.Equal(60*time.Second, cli.Timeout)
+	_, err = cli.Get(tlsServer.URL)
+	r.Error(err)
+
+	dir, err := ioutil.TempDir("", "provider")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+	caPath := filepath.Join(dir, "ca.pem")
+	r.NoError(ioutil.WriteFile(caPath, pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: tlsServer.Certificate().Raw,
+	}), 0644))
+	cli, err = newHTTPClient(schema.TestResourceDataRaw(t, provider.Schema, map[string]interface{}{
+		"ca_bundle_path":  caPath,
+		"request_timeout": 5,
+	}))
+	r.NoError(err)
+	r.Equal(5*time.Second, cli.Timeout)
+	resp, err := cli.Get(tlsServer.URL)
+	r.NoError(err)
+	resp.Body.Close()
+
+	// requests go through the proxy
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		proxied = req.URL.String()
+	}))
+	defer proxy.Close()
+	cli, err = newHTTPClient(schema.TestResourceDataRaw(t, provider.Schema, map[string]interface{}{
+		"proxy_url": proxy.URL,
+	}))
+	r.NoError(err)
+	resp, err = cli.Get("http://pipelines.example.com/api/pipelines")
+	r.NoError(err)
+	resp.Body.Close()
+	r.Equal("http://pipelines.example.com/api/pipelines", proxied)
+}
+
+func TestProvider_ValidateMinimal(t *testing.T) {
+	r := require.New(t)
+	if endpoint, ok := os.LookupEnv("AWS_S3_ENDPOINT"); ok {
+		os.Unsetenv("AWS_S3_ENDPOINT")
+		defer os.Setenv("AWS_S3_ENDPOINT", endpoint)
+	}
+	provider := Provider().(*schema.Provider)
+	_, errs := provider.Validate(terraform.NewResourceConfigRaw(map[string]interface{}{
+		"api_root": "http://pipelines.example.com",
+	}))
+	r.Empty(errs)
+
+	_, errs = provider.Validate(terraform.NewResourceConfigRaw(map[string]interface{}{
+		"api_root":    "http://pipelines.example.com",
+		"s3_endpoint": "minio:9000",
+	}))
+	r.NotEmpty(errs)
+}
+
+// s3Server is an in-process stand-in of S3-compatible storage (MinIO),
+// keeping objects of path-style requests in memory
+type s3Server struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	objects map[string]s3Object
+	// authorization is the last Authorization header received
+	authorization string
+}
+
+type s3Object struct {
+	body   []byte
+	header http.Header
+}
+
+func newS3Server(t *testing.T) *s3Server {
+	s := &s3Server{objects: map[string]s3Object{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.authorization = req.Header.Get("Authorization")
+		switch req.Method {
+		case http.MethodPut:
+			body, _ := ioutil.ReadAll(req.Body)
+			header := http.Header{}
+			for k, v := range req.Header {
+				if strings.HasPrefix(k, "X-Amz-Meta-") || k == "Content-Type" {
+					header[k] = v
+				}
+			}
+			header.Set("ETag", fmt.Sprintf(`"%x"`, md5.Sum(body)))
+			s.objects[req.URL.Path] = s3Object{body: body, header: header}
+			w.Header().Set("ETag", header.Get("ETag"))
+		case http.MethodHead, http.MethodGet:
+			obj, ok := s.objects[req.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				if req.Method == http.MethodGet {
+					w.Write([]byte(`<Error><Code>NoSuchKey</Code></Error>`))
+				}
+				return
+			}
+			for k, v := range obj.header {
+				w.Header()[k] = v
+			}
+			w.Header().Set("Content-Length", strconv.Itoa(len(obj.body)))
+			if req.Method == http.MethodGet {
+				w.Write(obj.body)
+			}
+		case http.MethodDelete:
+			delete(s.objects, req.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotImplemented)
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestProvider_S3Endpoint(t *testing.T) {
+	r := require.New(t)
+	srv := newS3Server(t)
+	provider := Provider().(*schema.Provider)
+	meta, err := providerConfigure(context.Background(), schema.TestResourceDataRaw(t, provider.Schema, map[string]interface{}{
+		"api_root":            "http://pipelines.example.com",
+		"s3_endpoint":         srv.URL,
+		"s3_force_path_style": true,
+		"aws_access_key":      "minio",
+		"aws_secret_key":      "minio123",
+	}))
+	r.NoError(err)
+
+	ctx := context.Background()
+	store := storage.NewS3(meta.(*providerConfig).Session, "charts", storage.S3Options{})
+	r.NoError(store.Put(ctx, &storage.Object{
+		Key:         "helm/web-abc.zip",
+		ContentType: "application/zip",
+		Metadata:    map[string]string{HashMetaHeader: "abc"},
+	}, bytes.NewReader([]byte("zip"))))
+	r.Contains(srv.authorization, "Credential=minio/")
+	r.Contains(srv.objects, "/charts/helm/web-abc.zip")
+
+	obj, err := store.Head(ctx, "helm/web-abc.zip")
+	r.NoError(err)
+	r.Equal(int64(3), obj.Size)
+	r.Equal("abc", obj.Metadata[HashMetaHeader])
+}
@@ -5,13 +5,24 @@
 package cicd
 
 import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
-
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/s3"
+	"os"
+	"path"
+	"strings"
+	"time"
 
 	"github.com/AtlantPlatform/terraform-provider-cicd/internal/helmchart"
+	"github.com/AtlantPlatform/terraform-provider-cicd/internal/helmrepo"
+	"github.com/AtlantPlatform/terraform-provider-cicd/internal/oci"
+	"github.com/AtlantPlatform/terraform-provider-cicd/internal/storage"
+	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/helper/validation"
 )
 
 func resourceHelmChart() *schema.Resource {
@@ -27,21 +38,114 @@ func resourceHelmChart() *schema.Resource {
 
 		SchemaVersion: 1,
 
+		CustomizeDiff: validateHelmChart,
+
 		Schema: map[string]*schema.Schema{
 			"source": {
 				Type:        schema.TypeString,
 				Required:    true,
 				Description: "local folder where HELM chart is originally located",
 			},
+			"storage": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ForceNew:     true,
+				Default:      storageS3,
+				ValidateFunc: validation.StringInSlice([]string{storageS3, storageLocal, storageHTTP}, false),
+				Description:  "Storage backend for ZIP of HELM chart: s3 (default), local or http",
+			},
 			"aws_bucket": {
 				Type:        schema.TypeString,
-				Required:    true,
-				Description: "AWS S3 bucket where ZIP of HELM chart will be uploaded",
+				Optional:    true,
+				ForceNew:    true,
+				Description: "AWS S3 bucket where ZIP of HELM chart will be uploaded (s3 storage)",
+			},
+			"s3_part_size": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      5,
+				ValidateFunc: validation.IntAtLeast(5),
+				Description:  "size of parts in MB for multipart upload of large archives (s3 storage)",
+			},
+			"s3_concurrency": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      5,
+				ValidateFunc: validation.IntAtLeast(1),
+				Description:  "number of parts uploaded in parallel (s3 storage)",
+			},
+			"s3_kms_key_id": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "KMS key (ID, ARN or alias) for server-side encryption of uploaded objects (s3 storage)",
+			},
+			"s3_tags": {
+				Type:        schema.TypeMap,
+				Optional:    true,
+				Description: "tags of uploaded objects, i.e. for cost allocation (s3 storage)",
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
+			"s3_acl": {
+				Type:     schema.TypeString,
+				Optional: true,
+				ValidateFunc: validation.StringInSlice([]string{
+					s3.ObjectCannedACLPrivate,
+					s3.ObjectCannedACLPublicRead,
+					s3.ObjectCannedACLPublicReadWrite,
+					s3.ObjectCannedACLAuthenticatedRead,
+					s3.ObjectCannedACLAwsExecRead,
+					s3.ObjectCannedACLBucketOwnerRead,
+					s3.ObjectCannedACLBucketOwnerFullControl,
+				}, false),
+				Description: "canned ACL of uploaded objects, i.e. bucket-owner-full-control (s3 storage)",
+			},
+			"s3_storage_class": {
+				Type:     schema.TypeString,
+				Optional: true,
+				// archived classes are not allowed, as charts must be readable right away
+				ValidateFunc: validation.StringInSlice([]string{
+					s3.StorageClassStandard,
+					s3.StorageClassReducedRedundancy,
+					s3.StorageClassStandardIa,
+					s3.StorageClassOnezoneIa,
+					s3.StorageClassIntelligentTiering,
+				}, false),
+				Description: "storage class of uploaded objects, i.e. STANDARD_IA (s3 storage)",
+			},
+			"local_path": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				ForceNew:    true,
+				Description: "local folder where ZIP of HELM chart will be saved (local storage)",
+			},
+			"http_url": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ForceNew:     true,
+				ValidateFunc: validation.IsURLWithHTTPorHTTPS,
+				Description:  "base URL where ZIP of HELM chart will be uploaded with PUT request (http storage)",
+			},
+			"http_headers": {
+				Type:        schema.TypeMap,
+				Optional:    true,
+				Sensitive:   true,
+				Description: "headers added to requests to http storage, i.e. Authorization",
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
+			"overrides": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ValidateFunc: validateOverrides,
+				Description:  "YAML or JSON document merged over values.yaml (i.e. yamlencode of nested values)",
 			},
 			"args": {
 				Type:        schema.TypeMap,
 				Optional:    true,
-				Description: "Arguments for values.yaml substitutions - for its installation",
+				Description: "Arguments for values.yaml substitutions - for its installation (dotted keys, values typed like helm --set: true, false, null and integers are converted, the rest are strings)",
 				Elem: &schema.Schema{
 					Type: schema.TypeString,
 				},
@@ -54,20 +158,85 @@ func resourceHelmChart() *schema.Resource {
 				},
 				Description: "list of allowed parameters to be overwritten",
 			},
+			"format": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ForceNew:     true,
+				Default:      helmchart.FormatZIP,
+				ValidateFunc: validation.StringInSlice([]string{helmchart.FormatZIP, helmchart.FormatTGZ}, false),
+				Description:  "archive format: zip for pipelines server (default) or tgz for standard HELM chart package (without overrides, args and allowed)",
+			},
+			"update_index": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "maintain HELM repository index.yaml next to chart packages (requires tgz format)",
+			},
+			"render": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "render templates (subcharts included) with merged values and run helm lint during plan, like helm template does (no cluster needed, lookup finds nothing)",
+			},
+			"manifests": {
+				Type:        schema.TypeMap,
+				Computed:    true,
+				Description: "output value: rendered manifests by template location, when render is set; kept until the chart content (source files, overrides) changes, as templates may be not deterministic",
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
+			"oci_repository": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "OCI registry namespace (oci://host/path) where chart package is pushed as path/<chart name>, tagged with chart version ('+' replaced by '_'), like helm push does",
+			},
+			"oci_username": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "username for OCI registry",
+			},
+			"oci_password": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Sensitive:   true,
+				Description: "password or token for OCI registry",
+			},
+			"oci_plain_http": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "use plain HTTP for OCI registry (local registries only)",
+			},
+			"oci_digest": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "output value: digest of the chart manifest in OCI registry",
+			},
 			"name": {
 				Type:        schema.TypeString,
 				Computed:    true,
 				Description: "output value: name of the chart taken from Chart.yaml",
 			},
+			"version": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "output value: version of the chart taken from Chart.yaml",
+			},
 			"archive": {
 				Type:        schema.TypeString,
 				Computed:    true,
-				Description: "output value: file name on AWS S3 bucket",
+				Description: "output value: file name in the storage",
 			},
 			"hash": {
 				Type:        schema.TypeString,
 				Computed:    true,
-				Description: "hash of ZIP file",
+				Description: "output value: hash of the archive content (chart files, overrides and allowed list)",
+			},
+			"archive_sha256": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "output value: sha256 of the archive, verified after upload",
 			},
 		},
 	}
@@ -76,70 +245,261 @@ func resourceHelmChart() *schema.Resource {
 // HashMetaHeader added to s3 as meta-data
 const HashMetaHeader = "chart-hash"
 
+// ArchiveMetaHeader is sha256 of the archive, added to s3 as meta-data
+const ArchiveMetaHeader = "archive-sha256"
+
+// IndexKey is location of HELM repository index in the storage
+const IndexKey = "helm/index.yaml"
+
+const (
+	storageS3    = "s3"
+	storageLocal = "local"
+	storageHTTP  = "http"
+)
+
+// newChartStorage creates storage backend selected in the resource
+func newChartStorage(d *schema.ResourceData, meta interface{}) (storage.Storage, error) {
+	config := meta.(*providerConfig)
+	switch SafeString(d, "storage") {
+	case storageLocal:
+		path := SafeString(d, "local_path")
+		if path == "" {
+			return nil, errors.New("local_path is required for local storage")
+		}
+		return storage.NewLocal(path), nil
+	case storageHTTP:
+		url := SafeString(d, "http_url")
+		if url == "" {
+			return nil, errors.New("http_url is required for http storage")
+		}
+		return storage.NewHTTP(url, SafeStringMap(d, "http_headers"), config.StorageHTTPClient), nil
+	default:
+		bucket := SafeString(d, "aws_bucket")
+		if bucket == "" {
+			return nil, errors.New("aws_bucket is required for s3 storage")
+		}
+		return storage.NewS3(config.Session, bucket, storage.S3Options{
+			PartSize:     int64(d.Get("s3_part_size").(int)) * 1024 * 1024,
+			Concurrency:  d.Get("s3_concurrency").(int),
+			KMSKeyID:     SafeString(d, "s3_kms_key_id"),
+			Tags:         SafeStringMap(d, "s3_tags"),
+			ACL:          SafeString(d, "s3_acl"),
+			StorageClass: SafeString(d, "s3_storage_class"),
+		}), nil
+	}
+}
+
+func validateHelmChart(d *schema.ResourceDiff, meta interface{}) error {
+	if d.Get("update_index").(bool) && d.Get("format").(string) != helmchart.FormatTGZ {
+		return errors.New("update_index requires tgz format, as HELM can't install other archives")
+	}
+	if err := checkPackageOverrides(d.Get("format").(string), d.Get("overrides").(string),
+		d.Get("args").(map[string]interface{}), d.Get("allowed").([]interface{})); err != nil {
+		return err
+	}
+	if d.Id() != "" && (!d.NewValueKnown("overrides") || !d.NewValueKnown("args") ||
+		!d.NewValueKnown("allowed") || !d.NewValueKnown("source")) {
+		if err := forceNewChart(d); err != nil {
+			return err
+		}
+	}
+	if !d.NewValueKnown("overrides") || !d.NewValueKnown("args") || !d.NewValueKnown("allowed") {
+		return nil
+	}
+	overrides, err := helmchart.ParseOverrides(d.Get("overrides").(string), d.Get("args").(map[string]interface{}))
+	if err != nil {
+		return err
+	}
+	allowed := []string{}
+	for _, v := range d.Get("allowed").([]interface{}) {
+		allowed = append(allowed, v.(string))
+	}
+	if !d.NewValueKnown("source") {
+		return helmchart.CheckAllowed(overrides, allowed)
+	}
+	source := d.Get("source").(string)
+	values, err := helmchart.LoadValues(source)
+	if err != nil {
+		return err
+	}
+	dependencies, err := helmchart.Dependencies(source)
+	if err != nil {
+		return err
+	}
+	if err := helmchart.CheckOverrides(values, overrides, dependencies, allowed); err != nil {
+		return fmt.Errorf("%s: %v", source, err)
+	}
+	if err := helmchart.ValidateSchema(source, helmchart.MergeValues(values, overrides)); err != nil {
+		return fmt.Errorf("%s: %v", source, err)
+	}
+	chart, err := helmchart.New(source, overrides, allowed)
+	if err != nil {
+		return err
+	}
+	// archive location is content-addressed, so any change
+	// of shipped content (including overrides) requires a new chart
+	if d.Id() != "" && d.Id() != chart.ID {
+		if err := d.SetNew("hash", chart.Hash); err != nil {
+			return err
+		}
+		if err := d.ForceNew("hash"); err != nil {
+			return err
+		}
+	}
+	if !d.Get("render").(bool) {
+		if len(d.Get("manifests").(map[string]interface{})) > 0 {
+			return d.SetNew("manifests", map[string]interface{}{})
+		}
+		return nil
+	}
+	// templates may be not deterministic (randAlphaNum, genCA, uuidv4, now),
+	// so manifests of the same chart are kept, rather than planned on every run
+	if d.Id() == chart.ID && !d.HasChange("render") && len(d.Get("manifests").(map[string]interface{})) > 0 {
+		return nil
+	}
+	manifests, err := renderChart(chart)
+	if err != nil {
+		return fmt.Errorf("%s: %v", source, err)
+	}
+	return d.SetNew("manifests", manifests)
+}
+
+// forceNewChart replaces the chart, which content is known on apply only:
+// archive location is content-addressed, so it can't be changed by update
+func forceNewChart(d *schema.ResourceDiff) error {
+	if err := d.SetNewComputed("hash"); err != nil {
+		return err
+	}
+	if d.Get("render").(bool) {
+		if err := d.SetNewComputed("manifests"); err != nil {
+			return err
+		}
+	}
+	return d.ForceNew("hash")
+}
+
+// renderChart renders templates of the chart and lints the result
+func renderChart(chart *helmchart.Builder) (map[string]interface{}, error) {
+	manifests, err := chart.Render()
+	if err != nil {
+		return nil, err
+	}
+	if err := chart.Lint(); err != nil {
+		return nil, err
+	}
+	res := make(map[string]interface{}, len(manifests))
+	for name, body := range manifests {
+		res[name] = body
+	}
+	return res, nil
+}
+
+// setManifests saves rendered manifests, if render is set.
+// Manifests planned by validateHelmChart are kept, as rendering again may change them
+func setManifests(d *schema.ResourceData, chart *helmchart.Builder) error {
+	if !d.Get("render").(bool) {
+		d.Set("manifests", map[string]interface{}{})
+		return nil
+	}
+	if len(d.Get("manifests").(map[string]interface{})) > 0 {
+		return nil
+	}
+	manifests, err := renderChart(chart)
+	if err != nil {
+		return err
+	}
+	d.Set("manifests", manifests)
+	return nil
+}
+
+// checkPackageOverrides rejects overrides of tgz archive: chart package has no place for them,
+// so helm install of the package would ignore them
+func checkPackageOverrides(format, overrides string, args map[string]interface{}, allowed []interface{}) error {
+	if format == helmchart.FormatTGZ && (overrides != "" || len(args) > 0 || len(allowed) > 0) {
+		return errors.New("overrides, args and allowed are not supported by tgz format, values are set on helm install")
+	}
+	return nil
+}
+
+// newChart reads chart from the source folder with overrides of the resource
+func newChart(d *schema.ResourceData) (*helmchart.Builder, error) {
+	if err := checkPackageOverrides(SafeString(d, "format"), SafeString(d, "overrides"),
+		d.Get("args").(map[string]interface{}), d.Get("allowed").([]interface{})); err != nil {
+		return nil, err
+	}
+	overrides, err := helmchart.ParseOverrides(SafeString(d, "overrides"), d.Get("args").(map[string]interface{}))
+	if err != nil {
+		return nil, err
+	}
+	return helmchart.New(d.Get("source").(string), overrides, SafeStringList(d, "allowed"))
+}
+
+func validateOverrides(v interface{}, k string) ([]string, []error) {
+	if _, err := helmchart.ParseOverrides(v.(string), nil); err != nil {
+		return nil, []error{fmt.Errorf("%s: %v", k, err)}
+	}
+	return nil, nil
+}
+
 func onHelmChartCreate(d *schema.ResourceData, meta interface{}) error {
 	log.Printf("onHelmChartCreate: start %v", d)
-	cli := s3.New(meta.(*providerConfig).Session)
+	store, err := newChartStorage(d, meta)
+	if err != nil {
+		return err
+	}
 
-	source := d.Get("source").(string)
-	args := d.Get("args").(map[string]interface{})
-	chart, err := helmchart.New(source, args, SafeStringList(d, "allowed"))
+	chart, err := newChart(d)
 	if err != nil {
 		return err
 	}
+	if err := setManifests(d, chart); err != nil {
+		return err
+	}
 
-	// upload it to S3, return its location
-	reader, errZip := chart.ZIP()
-	if errZip != nil {
-		return errZip
+	archive, err := newChartArchive(chart, SafeString(d, "format"))
+	if err != nil {
+		return err
 	}
-	if _, errUpload := cli.PutObject(&s3.PutObjectInput{
-		Body:        reader,
-		Bucket:      aws.String(SafeString(d, "aws_bucket")),
-		ContentType: aws.String("application/zip"),
-		Key:         aws.String(chart.GetZipName()),
-		Metadata: map[string]*string{
-			HashMetaHeader: &chart.Hash,
-		},
-	}); errUpload != nil {
-		return errUpload
+	defer archive.Close()
+
+	// upload it to the storage, return its location
+	if err := uploadChart(d, meta, store, chart, archive); err != nil {
+		return err
+	}
+	if err := addToIndex(d, meta, store, chart, archive.SHA256); err != nil {
+		return err
 	}
 
+	// push it to OCI registry, if requested
+	if err := pushChart(d, meta, chart, archive); err != nil {
+		return err
+	}
+
+	d.Set("archive_sha256", archive.SHA256)
 	d.Set("hash", chart.Hash)
 	d.Set("name", chart.Name)
-	d.Set("archive", chart.GetZipName())
+	d.Set("version", chart.Version)
+	d.Set("archive", chart.GetArchiveName(SafeString(d, "format")))
 	d.SetId(chart.ID)
 	return nil
 }
 
 func onHelmChartRead(d *schema.ResourceData, meta interface{}) error {
-	// cli := s3.New(meta.(*providerConfig).Session)
 	log.Printf("onHelmChartRead: start %v", d)
 	if d.Get("name") != nil {
-		// 1. reading local chart information
-		source := d.Get("source").(string)
-		args := d.Get("args").(map[string]interface{})
-		localChart, err := helmchart.New(source, args, SafeStringList(d, "allowed"))
-		if err != nil {
-			return err
+		// 1. reading external information
+		remoteChart := &helmchart.Builder{
+			ID:      d.Id(),
+			Name:    SafeString(d, "name"),
+			Version: SafeString(d, "version"),
+		}
+		if remoteChart.GetArchiveName(SafeString(d, "format")) != "" {
+			if err := checkRemoteChart(d, meta, remoteChart); err != nil {
+				return err
+			}
 		}
-		d.Set("hash", localChart.Hash)
-		d.Set("archive", localChart.GetZipName())
-		log.Printf("onHelmChartRead: checksum: %v %v", localChart.Hash, d)
-
-		// 2. reading external information
-		// remoteChart := &helmchart.Builder{
-		// 	ID:   d.Id(),
-		// 	Name: d.Get("name").(string),
-		// }
-		// // check s3 location
-		// _, errRead := cli.HeadObject(&s3.HeadObjectInput{
-		// 	Bucket: aws.String(d.Get("aws_bucket").(string)),
-		// 	Key:    aws.String(remoteChart.GetZipName()),
-		// })
-		// if errRead != nil {
-		// 	// no error, but nothing is present on s3, need regeneration
-		// 	return nil
-		// }
+		// local chart is not read here: hash and archive describe the uploaded content,
+		// changes of the local chart are detected on plan (see validateHelmChart)
 	}
 	return nil
 }
@@ -148,52 +508,255 @@ func onHelmChartRead(d *schema.ResourceData, meta interface{}) error {
 // on chart modification, as ID should be changed,
 // old resource is a subject of deletion
 func onHelmChartUpdate(d *schema.ResourceData, meta interface{}) error {
-	cli := s3.New(meta.(*providerConfig).Session)
+	store, err := newChartStorage(d, meta)
+	if err != nil {
+		return err
+	}
 
-	source := d.Get("source").(string)
-	args := d.Get("args").(map[string]interface{})
-	localChart, err := helmchart.New(source, args, SafeStringList(d, "allowed"))
+	localChart, err := newChart(d)
+	if err != nil {
+		return err
+	}
+	if err := setManifests(d, localChart); err != nil {
+		return err
+	}
+
+	archive, err := newChartArchive(localChart, SafeString(d, "format"))
 	if err != nil {
 		return err
 	}
+	defer archive.Close()
 
-	// upload it to S3, return its location
-	reader, errZip := localChart.ZIP()
-	if errZip != nil {
-		return errZip
+	// upload it to the storage, return its location
+	if err := uploadChart(d, meta, store, localChart, archive); err != nil {
+		return err
 	}
-	if _, errUpload := cli.PutObject(&s3.PutObjectInput{
-		Body:        reader,
-		Bucket:      aws.String(SafeString(d, "aws_bucket")),
-		ContentType: aws.String("application/zip"),
-		Key:         aws.String(localChart.GetZipName()),
-		Metadata: map[string]*string{
-			HashMetaHeader: aws.String(localChart.Hash),
-		},
-	}); errUpload != nil {
-		return errUpload
+	if err := addToIndex(d, meta, store, localChart, archive.SHA256); err != nil {
+		return err
+	}
+
+	// push it to OCI registry, if requested
+	if err := pushChart(d, meta, localChart, archive); err != nil {
+		return err
 	}
 
+	d.Set("archive_sha256", archive.SHA256)
 	d.Set("hash", localChart.Hash)
 	d.Set("name", localChart.Name)
-	d.Set("archive", localChart.GetZipName())
+	d.Set("version", localChart.Version)
+	d.Set("archive", localChart.GetArchiveName(SafeString(d, "format")))
 	return nil
 }
 
 func onHelmChartDelete(d *schema.ResourceData, meta interface{}) error {
-	// remove file from AWS s3 bucket
+	// remove file from the storage
 	if d.Get("name") != nil {
 		remoteChart := &helmchart.Builder{
-			ID:   d.Id(),
-			Name: SafeString(d, "name"),
+			ID:      d.Id(),
+			Name:    SafeString(d, "name"),
+			Version: SafeString(d, "version"),
+		}
+		store, err := newChartStorage(d, meta)
+		if err != nil {
+			return err
 		}
-		session := meta.(*providerConfig).Session
-		if _, errDelete := s3.New(session).DeleteObject(&s3.DeleteObjectInput{
-			Bucket: aws.String(SafeString(d, "aws_bucket")),
-			Key:    aws.String(remoteChart.GetZipName()),
-		}); errDelete != nil {
+		ctx := meta.(*providerConfig).StopContext
+		key := remoteChart.GetArchiveName(SafeString(d, "format"))
+		if d.Get("update_index").(bool) && key != "" {
+			if err := helmrepo.Update(ctx, store, IndexKey, func(index *helmrepo.Index) {
+				index.Remove(remoteChart.Name, indexURL(key))
+			}); err != nil {
+				return err
+			}
+		}
+		if errDelete := store.Delete(ctx, key); errDelete != nil {
 			log.Printf("[WARN] removal failed %v", errDelete)
 		}
 	}
 	return nil
 }
+
+// checkRemoteChart marks resource for recreation
+// when archive is missing in the storage or its hash differs
+func checkRemoteChart(d *schema.ResourceData, meta interface{}, remoteChart *helmchart.Builder) error {
+	store, err := newChartStorage(d, meta)
+	if err != nil {
+		return err
+	}
+	ctx := meta.(*providerConfig).StopContext
+	key := remoteChart.GetArchiveName(SafeString(d, "format"))
+	obj, errRead := store.Head(ctx, key)
+	if errRead == storage.ErrNotFound {
+		// no error, but nothing is present in the storage, need regeneration
+		log.Printf("[WARN] %v is missing, chart will be recreated", key)
+		d.SetId("")
+		return nil
+	} else if errRead != nil {
+		return errRead
+	}
+	// storage may not keep metadata (i.e. http server ignoring custom headers),
+	// then the hash is unknown rather than different
+	if remoteHash, ok := obj.Metadata[HashMetaHeader]; !ok {
+		log.Printf("[DEBUG] %v has no %v metadata, hash is not checked", key, HashMetaHeader)
+	} else if remoteHash != SafeString(d, "hash") {
+		log.Printf("[WARN] %v hash %v doesn't match %v, chart will be recreated",
+			key, remoteHash, SafeString(d, "hash"))
+		d.SetId("")
+		return nil
+	}
+	// archives uploaded before archive_sha256 was introduced have nothing to compare
+	digest := SafeString(d, "archive_sha256")
+	if remoteDigest, ok := obj.Metadata[ArchiveMetaHeader]; ok && digest != "" && remoteDigest != digest {
+		log.Printf("[WARN] %v sha256 %v doesn't match %v, chart will be recreated",
+			key, remoteDigest, digest)
+		d.SetId("")
+	}
+	return nil
+}
+
+// chartArchive is chart archive streamed to temporary file, as large charts may not fit into memory.
+// The same file is uploaded to the storage and pushed to OCI registry
+type chartArchive struct {
+	*os.File
+	Format      string
+	ContentType string
+	Size        int64
+	// SHA256 is hex encoded sha256 of the archive
+	SHA256 string
+}
+
+// newChartArchive writes archive of the chart in the format, caller must close it
+func newChartArchive(chart *helmchart.Builder, format string) (*chartArchive, error) {
+	f, err := ioutil.TempFile("", "helmchart-*."+format)
+	if err != nil {
+		return nil, fmt.Errorf("temporary file failure %v", err)
+	}
+	archive := &chartArchive{File: f, Format: format}
+	h := sha256.New()
+	if archive.ContentType, err = chart.WriteArchive(io.MultiWriter(f, h), format); err == nil {
+		archive.Size, err = f.Seek(0, io.SeekCurrent)
+	}
+	if err != nil {
+		archive.Close()
+		return nil, err
+	}
+	archive.SHA256 = fmt.Sprintf("%x", h.Sum(nil))
+	return archive, nil
+}
+
+// Close removes the temporary file
+func (a *chartArchive) Close() error {
+	a.File.Close()
+	return os.Remove(a.Name())
+}
+
+// reader reads the archive from its start. It is not a Closer,
+// as HTTP clients close request bodies, while the archive is read more than once
+func (a *chartArchive) reader() io.ReadSeeker {
+	return io.NewSectionReader(a.File, 0, a.Size)
+}
+
+// uploadChart puts chart archive to the storage and verifies it
+func uploadChart(d *schema.ResourceData, meta interface{}, store storage.Storage, chart *helmchart.Builder, archive *chartArchive) error {
+	ctx := meta.(*providerConfig).StopContext
+	key := chart.GetArchiveName(archive.Format)
+	if err := store.Put(ctx, &storage.Object{
+		Key:         key,
+		ContentType: archive.ContentType,
+		Metadata: map[string]string{
+			HashMetaHeader:    chart.Hash,
+			ArchiveMetaHeader: archive.SHA256,
+		},
+	}, archive.reader()); err != nil {
+		return err
+	}
+
+	// S3 storage verifies the content by checksums it computes on Put.
+	// http servers may keep neither size (-1) nor metadata, so only reported ones are compared
+	obj, err := store.Head(ctx, key)
+	if err != nil {
+		return fmt.Errorf("%s verification failure %v", key, err)
+	}
+	stored, ok := obj.Metadata[ArchiveMetaHeader]
+	if (obj.Size >= 0 && obj.Size != archive.Size) || (ok && stored != archive.SHA256) {
+		return fmt.Errorf("%s verification failure: stored %d bytes with sha256 %s, uploaded %d bytes with sha256 %s",
+			key, obj.Size, stored, archive.Size, archive.SHA256)
+	}
+	return nil
+}
+
+// addToIndex registers chart package in HELM repository index, if update_index is set
+func addToIndex(d *schema.ResourceData, meta interface{}, store storage.Storage, chart *helmchart.Builder, digest string) error {
+	if !d.Get("update_index").(bool) {
+		return nil
+	}
+	decl := chart.GetDeclaration()
+	key := chart.GetArchiveName(SafeString(d, "format"))
+	return helmrepo.Update(meta.(*providerConfig).StopContext, store, IndexKey, func(index *helmrepo.Index) {
+		index.Add(&helmrepo.ChartVersion{
+			APIVersion:  decl.ApiVersion,
+			Name:        decl.Name,
+			Version:     decl.Version,
+			AppVersion:  decl.AppVersion,
+			Description: decl.Description,
+			URLs:        []string{indexURL(key)},
+			Digest:      digest,
+			Created:     time.Now().UTC(),
+		})
+	})
+}
+
+// indexURL is location of the archive relative to the index
+func indexURL(key string) string {
+	return strings.TrimPrefix(key, path.Dir(IndexKey)+"/")
+}
+
+// pushChart pushes chart package to OCI registry, if oci_repository is set.
+// Charts are not removed from the registry on deletion, as version tags are shared
+func pushChart(d *schema.ResourceData, meta interface{}, chart *helmchart.Builder, archive *chartArchive) error {
+	repository := SafeString(d, "oci_repository")
+	if repository == "" {
+		d.Set("oci_digest", "")
+		return nil
+	}
+	host, namespace, err := oci.ParseReference(repository)
+	if err != nil {
+		return err
+	}
+	// helm push and helm install address charts by name under the namespace
+	name := namespace + "/" + chart.Name
+	config, err := chart.ConfigJSON()
+	if err != nil {
+		return err
+	}
+	layer := archive
+	if archive.Format != helmchart.FormatTGZ {
+		// zip archive is for the pipelines server, registry keeps chart package
+		if layer, err = newChartArchive(chart, helmchart.FormatTGZ); err != nil {
+			return err
+		}
+		defer layer.Close()
+	}
+
+	cli := oci.New(meta.(*providerConfig).RegistryHTTPClient)
+	cli.Username = SafeString(d, "oci_username")
+	cli.Password = SafeString(d, "oci_password")
+	cli.PlainHTTP = d.Get("oci_plain_http").(bool)
+	// '+' of semver build metadata is not allowed in tags, helm replaces it with '_'
+	tag := strings.Replace(chart.Version, "+", "_", -1)
+	digest, err := cli.Push(meta.(*providerConfig).StopContext, host, name, tag,
+		oci.NewBlob(helmchart.ConfigMediaType, config),
+		&oci.Blob{
+			MediaType: helmchart.ChartLayerMediaType,
+			Content:   layer.reader(),
+			Size:      layer.Size,
+			Digest:    "sha256:" + layer.SHA256,
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("OCI push failure %v", err)
+	}
+	log.Printf("pushChart: %s/%s:%s %s", host, name, tag, digest)
+	d.Set("oci_digest", digest)
+	return nil
+}
@@ -0,0 +1,296 @@
+// Copyright 2017-2021 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package cicd
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AtlantPlatform/terraform-provider-cicd/internal/helmchart"
+	"github.com/AtlantPlatform/terraform-provider-cicd/internal/storage"
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"github.com/stretchr/testify/require"
+)
+
+// changing storage backend must not leave the archive orphaned in the old one
+func TestHelmChart_StorageForceNew(t *testing.T) {
+	s := resourceHelmChart().Schema
+	for _, key := range []string{"storage", "aws_bucket", "local_path", "http_url"} {
+		require.True(t, s[key].ForceNew, key)
+	}
+}
+
+func TestHelmChart_CheckRemoteChart(t *testing.T) {
+	r := require.New(t)
+	headers := http.Header{}
+	found := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		for k, v := range headers {
+			w.Header()[k] = v
+		}
+	}))
+	defer srv.Close()
+	meta := &providerConfig{StorageHTTPClient: srv.Client(), StopContext: context.Background()}
+
+	check := func() string {
+		d := resourceHelmChart().TestResourceData()
+		d.SetId("abc")
+		r.NoError(d.Set("storage", storageHTTP))
+		r.NoError(d.Set("http_url", srv.URL))
+		r.NoError(d.Set("hash", "abc123"))
+		r.NoError(d.Set("archive_sha256", "sha"))
+		remote := &helmchart.Builder{ID: d.Id(), Name: "web", Version: "0.1.0"}
+		r.NoError(checkRemoteChart(d, meta, remote))
+		return d.Id()
+	}
+
+	// server doesn't keep metadata: nothing to compare
+	r.Equal("abc", check())
+
+	headers.Set(storage.MetaHeaderPrefix+ArchiveMetaHeader, "sha")
+	r.Equal("abc", check())
+	headers.Set(storage.MetaHeaderPrefix+ArchiveMetaHeader, "other")
+	r.Equal("", check())
+	headers.Del(storage.MetaHeaderPrefix + ArchiveMetaHeader)
+
+	headers.Set(storage.MetaHeaderPrefix+HashMetaHeader, "abc123")
+	r.Equal("abc", check())
+
+	headers.Set(storage.MetaHeaderPrefix+HashMetaHeader, "other")
+	r.Equal("", check())
+
+	found = false
+	r.Equal("", check())
+}
+
+// newTestSource creates chart folder of the version
+func newTestSource(t *testing.T, version string) string {
+	source, err := ioutil.TempDir("", "chart")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(source) })
+	require.NoError(t, os.Mkdir(filepath.Join(source, "templates"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(source, "Chart.yaml"), []byte("apiVersion: v2\nname: web\nversion: "+version+"\n"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(source, "values.yaml"), []byte("replicas: 1\n"), 0644))
+	return source
+}
+
+// newTestChart builds chart of the version from a temporary folder
+func newTestChart(t *testing.T, version string) *helmchart.Builder {
+	chart, err := helmchart.New(newTestSource(t, version), nil, nil)
+	require.NoError(t, err)
+	return chart
+}
+
+// chart package has no place for overrides, so they are rejected with tgz format
+func TestHelmChart_PackageOverrides(t *testing.T) {
+	res := resourceHelmChart()
+	source := newTestSource(t, "0.1.0")
+	cases := []struct {
+		name   string
+		config map[string]interface{}
+		fails  bool
+	}{
+		{"zip overrides", map[string]interface{}{"overrides": "replicas: 2", "allowed": []interface{}{"replicas"}}, false},
+		{"tgz", map[string]interface{}{"format": "tgz"}, false},
+		{"tgz overrides", map[string]interface{}{"format": "tgz", "overrides": "replicas: 2"}, true},
+		{"tgz allowed", map[string]interface{}{"format": "tgz", "allowed": []interface{}{"replicas"}}, true},
+	}
+	for _, c := range cases {
+		raw := map[string]interface{}{"source": source}
+		for k, v := range c.config {
+			raw[k] = v
+		}
+		_, err := res.Diff(nil, testConfig(res, raw), nil)
+		if c.fails {
+			require.Error(t, err, c.name)
+			require.Contains(t, err.Error(), "not supported by tgz format", c.name)
+		} else {
+			require.NoError(t, err, c.name)
+		}
+	}
+
+	// args could be unknown on plan
+	d := schema.TestResourceDataRaw(t, res.Schema, map[string]interface{}{
+		"source": source,
+		"format": "tgz",
+		"args":   map[string]interface{}{"replicas": "2"},
+	})
+	_, err := newChart(d)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "not supported by tgz format")
+}
+
+// rendered manifests are kept until the chart changes, as templates may be random
+func TestHelmChart_KeepManifests(t *testing.T) {
+	r := require.New(t)
+	res := resourceHelmChart()
+	source := newTestSource(t, "0.1.0")
+	r.NoError(ioutil.WriteFile(filepath.Join(source, "templates", "secret.yaml"), []byte(`apiVersion: v1
+kind: Secret
+metadata:
+  name: {{ .Release.Name }}-web
+stringData:
+  password: {{ randAlphaNum 16 | quote }}
+`), 0644))
+	raw := map[string]interface{}{"source": source, "render": true}
+	chart, err := helmchart.New(source, map[string]interface{}{}, []string{})
+	r.NoError(err)
+	d := schema.TestResourceDataRaw(t, res.Schema, raw)
+	d.SetId(chart.ID)
+	r.NoError(d.Set("hash", chart.Hash))
+	r.NoError(d.Set("manifests", map[string]interface{}{"templates/secret.yaml": "rendered"}))
+	state := d.State()
+
+	config := testConfig(res, map[string]interface{}{"source": source, "render": "true"})
+	diff, err := res.Diff(state, config, nil)
+	r.NoError(err)
+	if diff != nil {
+		r.NotContains(diff.Attributes, "manifests.templates/secret.yaml")
+	}
+
+	// changed chart gets new manifests
+	r.NoError(ioutil.WriteFile(filepath.Join(source, "values.yaml"), []byte("replicas: 2\n"), 0644))
+	diff, err = res.Diff(state, config, nil)
+	r.NoError(err)
+	r.True(diff.RequiresNew())
+	r.Contains(diff.Attributes, "manifests.templates/secret.yaml")
+	r.NotEqual("rendered", diff.Attributes["manifests.templates/secret.yaml"].New)
+}
+
+// chart content unknown on plan may change its ID, so the chart is replaced
+func TestHelmChart_UnknownContent(t *testing.T) {
+	r := require.New(t)
+	res := resourceHelmChart()
+	source := newTestSource(t, "0.1.0")
+	raw := map[string]interface{}{"source": source, "overrides": "replicas: 2", "allowed": []interface{}{"replicas"}}
+	overrides, err := helmchart.ParseOverrides("replicas: 2", nil)
+	r.NoError(err)
+	chart, err := helmchart.New(source, overrides, []string{"replicas"})
+	r.NoError(err)
+	d := schema.TestResourceDataRaw(t, res.Schema, raw)
+	d.SetId(chart.ID)
+	r.NoError(d.Set("hash", chart.Hash))
+	state := d.State()
+
+	diff, err := res.Diff(state, testConfig(res, raw), nil)
+	r.NoError(err)
+	r.False(diff != nil && diff.RequiresNew())
+
+	for _, key := range []string{"overrides", "allowed", "source"} {
+		config := map[string]interface{}{}
+		for k, v := range raw {
+			config[k] = v
+		}
+		config[key] = unknown
+		diff, err := res.Diff(state, testConfig(res, config), nil)
+		r.NoError(err, key)
+		r.True(diff.RequiresNew(), key)
+		r.True(diff.Attributes["hash"].NewComputed, key)
+	}
+}
+
+// http servers may not keep metadata, then only the size is verified
+func TestHelmChart_UploadChart(t *testing.T) {
+	r := require.New(t)
+	chart := newTestChart(t, "0.1.0")
+	var stored []byte
+	headers := http.Header{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodPut {
+			stored, _ = ioutil.ReadAll(req.Body)
+			return
+		}
+		for k, v := range headers {
+			w.Header()[k] = v
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(stored)))
+	}))
+	defer srv.Close()
+	meta := &providerConfig{StopContext: context.Background()}
+	store := storage.NewHTTP(srv.URL, nil, srv.Client())
+
+	d := resourceHelmChart().TestResourceData()
+	archive, err := newChartArchive(chart, helmchart.FormatTGZ)
+	r.NoError(err)
+	defer archive.Close()
+	r.NoError(uploadChart(d, meta, store, chart, archive))
+	r.Equal(fmt.Sprintf("%x", sha256.Sum256(stored)), archive.SHA256)
+	r.Equal(archive.Size, int64(len(stored)))
+
+	headers.Set(storage.MetaHeaderPrefix+ArchiveMetaHeader, archive.SHA256)
+	r.NoError(uploadChart(d, meta, store, chart, archive))
+
+	headers.Set(storage.MetaHeaderPrefix+ArchiveMetaHeader, "other")
+	err = uploadChart(d, meta, store, chart, archive)
+	r.Error(err)
+	r.Contains(err.Error(), "verification failure")
+}
+
+func TestHelmChart_PushChart(t *testing.T) {
+	r := require.New(t)
+	chart := newTestChart(t, "0.1.0+build.7")
+
+	tags := []string{}
+	blobs := map[string][]byte{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		switch {
+		case req.Method == http.MethodHead:
+			w.WriteHeader(http.StatusNotFound)
+		case req.Method == http.MethodPost:
+			w.Header().Set("Location", req.URL.Path+"1")
+			w.WriteHeader(http.StatusAccepted)
+		case strings.Contains(req.URL.Path, "/manifests/"):
+			tags = append(tags, strings.TrimPrefix(req.URL.Path, "/v2/"))
+			w.WriteHeader(http.StatusCreated)
+		default:
+			blobs[req.URL.Query().Get("digest")] = body
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer srv.Close()
+
+	d := resourceHelmChart().TestResourceData()
+	r.NoError(d.Set("oci_repository", "oci://"+strings.TrimPrefix(srv.URL, "http://")+"/charts"))
+	r.NoError(d.Set("oci_plain_http", true))
+	meta := &providerConfig{
+		// pipelines API client settings must not apply to the registry
+		HTTPClient:         &http.Client{Timeout: time.Nanosecond},
+		RegistryHTTPClient: srv.Client(),
+		StopContext:        context.Background(),
+	}
+	// chart package stored in the storage is pushed as is
+	archive, err := newChartArchive(chart, helmchart.FormatTGZ)
+	r.NoError(err)
+	defer archive.Close()
+	r.NoError(pushChart(d, meta, chart, archive))
+	r.Equal([]string{"charts/web/manifests/0.1.0_build.7"}, tags)
+	r.NotEmpty(d.Get("oci_digest"))
+	layer, err := ioutil.ReadFile(archive.Name())
+	r.NoError(err)
+	r.Equal(layer, blobs["sha256:"+archive.SHA256])
+
+	// zip archive is not a chart package, so package is built for the registry
+	zip, err := newChartArchive(chart, helmchart.FormatZIP)
+	r.NoError(err)
+	defer zip.Close()
+	blobs = map[string][]byte{}
+	r.NoError(pushChart(d, meta, chart, zip))
+	r.Equal(layer, blobs["sha256:"+archive.SHA256])
+}
@@ -5,15 +5,11 @@
 package cicd
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"net/http"
-
 	"log"
 
 	"github.com/AtlantPlatform/terraform-provider-cicd/internal/helpers"
+	"github.com/AtlantPlatform/terraform-provider-cicd/internal/pipelinesapi"
 	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
 )
 
@@ -30,7 +26,9 @@ func resourcePipelineHelm() *schema.Resource {
 
 		SchemaVersion: 1,
 
-		Schema: map[string]*schema.Schema{
+		CustomizeDiff: validateApprovals,
+
+		Schema: withApprovals(map[string]*schema.Schema{
 			"archive": {
 				Type:        schema.TypeString,
 				Required:    true,
@@ -69,69 +67,27 @@ func resourcePipelineHelm() *schema.Resource {
 				Optional:    true,
 				Description: "Docker provider (aws, ibm, gitlab). AWS by default.",
 			},
-			"approvals_required": {
-				Type:        schema.TypeInt,
-				Optional:    true,
-				Default:     0,
-				Description: "Number of approvals required for the pipeline to be finished",
-			},
-			"approvers": {
-				Type:     schema.TypeList,
-				Optional: true,
-				Elem: &schema.Schema{
-					Type: schema.TypeString,
-				},
-				Description: "list of approvers",
-			},
 			"secret": {
 				Type:        schema.TypeString,
 				Computed:    true,
 				Description: "pipeline secret to use this pipeline",
 			},
-		},
+		}),
 	}
 }
 
 func onPipelineHelmCreate(d *schema.ResourceData, meta interface{}) error {
-	apiRoot := meta.(*providerConfig).APIRoot
-	payload := PipelineHelmCreate{
-		ID:               helpers.NewRandSeq(32),
-		Origin:           SafeString(d, "origin"),
-		RegistryURL:      SafeString(d, "registry_url"),
-		RegistryProvider: SafeString(d, "registry_provider"),
-		// helm-specific
-		Type:              PipelineKindHelm,
-		Archive:           SafeString(d, "archive"),
-		Release:           SafeString(d, "release"),
-		Namespace:         SafeString(d, "namespace"),
-		ApprovalsRequired: SafeNum(d, "approvals_required"),
-		Approvers:         SafeStringList(d, "approvers"),
-		Branches:          SafeStringList(d, "branches"),
-	}
-	body, _ := json.Marshal(&payload)
-	log.Printf("onPipelineHelmCreate activate %v", string(body))
-	resp, err := http.Post(apiRoot+"/api/pipelines/activate", "application/json", bytes.NewBuffer(body))
-	if err != nil {
-		return fmt.Errorf("activation error: %v", err.Error())
-	}
-	defer resp.Body.Close()
-
-	var out PipelineActivateResponse
-	buf, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
+	config := meta.(*providerConfig)
+	if err := checkApprovals(d); err != nil {
 		return err
 	}
-	if resp.StatusCode >= 300 {
-		return fmt.Errorf("API Init responded with status %v (%v)",
-			resp.StatusCode, string(buf))
-	}
+	payload := pipelineHelmPayload(d)
+	payload.ID = helpers.NewRandSeq(32)
 
-	if err := json.Unmarshal(buf, &out); err != nil {
-		return err
-	}
-	if payload.ID != out.ID {
-		return fmt.Errorf("IDs don't match, found %s, expected %s (%v)",
-			out.ID, payload.ID, string(buf))
+	log.Printf("onPipelineHelmCreate activate %v", payload.ID)
+	out, err := config.API.Activate(config.StopContext, payload)
+	if err != nil {
+		return fmt.Errorf("activation error: %v", err)
 	}
 	d.SetId(out.ID)
 	// secret comes back from pipelines server
@@ -140,82 +96,78 @@ func onPipelineHelmCreate(d *schema.ResourceData, meta interface{}) error {
 }
 
 func onPipelineHelmRead(d *schema.ResourceData, meta interface{}) error {
-	// nothing here so far. We fully trust local store
+	config := meta.(*providerConfig)
+	if len(d.Id()) == 0 {
+		return nil
+	}
+	ref := pipelinesapi.Ref{ID: d.Id(), Secret: SafeString(d, "secret")}
+	remote, err := config.API.Get(config.StopContext, ref)
+	if pipelinesapi.IsNotFound(err) {
+		log.Printf("[WARN] pipeline %v is gone, removing from state", ref.ID)
+		d.SetId("")
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("pipeline read error: %v", err)
+	}
+
+	d.Set("archive", remote.Archive)
+	d.Set("release", remote.Release)
+	d.Set("namespace", remote.Namespace)
+	d.Set("origin", remote.Origin)
+	d.Set("branches", remote.Branches)
+	d.Set("registry_url", remote.RegistryURL)
+	d.Set("registry_provider", remote.RegistryProvider)
+	setApprovals(d, remote.Approvals)
 	return nil
 }
 
 func onPipelineHelmUpdate(d *schema.ResourceData, meta interface{}) error {
-	apiRoot := meta.(*providerConfig).APIRoot
+	config := meta.(*providerConfig)
 	if len(d.Id()) == 0 {
 		return nil
 	}
-	payload := PipelineHelmCreate{
-		ID:               d.Id(),
-		Secret:           SafeString(d, "secret"),
-		Origin:           SafeString(d, "origin"),
-		RegistryURL:      SafeString(d, "registry_url"),
-		RegistryProvider: SafeString(d, "registry_provider"),
-		// helm-specific
-		Type:              PipelineKindHelm,
-		Archive:           SafeString(d, "archive"),
-		Release:           SafeString(d, "release"),
-		Namespace:         SafeString(d, "namespace"),
-		ApprovalsRequired: SafeNum(d, "approvals_required"),
-		Approvers:         SafeStringList(d, "approvers"),
-		Branches:          SafeStringList(d, "branches"),
-	}
-
-	body, _ := json.Marshal(&payload)
-	log.Printf("onPipelineHelmCreate activate %v", string(body))
-	resp, err := http.Post(apiRoot+"/api/pipelines/activate", "application/json", bytes.NewBuffer(body))
-	if err != nil {
-		return fmt.Errorf("activation error: %v", err.Error())
-	}
-	defer resp.Body.Close()
-	var out PipelineActivateResponse
-	buf, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
+	if err := checkApprovals(d); err != nil {
 		return err
 	}
-	if resp.StatusCode >= 300 {
-		return fmt.Errorf("API Init responded with status %v (%v)",
-			resp.StatusCode, string(body))
-	}
+	payload := pipelineHelmPayload(d)
+	payload.ID = d.Id()
+	payload.Secret = SafeString(d, "secret")
 
-	if err := json.Unmarshal(buf, &out); err != nil {
-		return err
-	}
-	if payload.ID != out.ID {
-		return fmt.Errorf("IDs don't match, found %s, expected %s", out.ID, payload.ID)
+	log.Printf("onPipelineHelmUpdate activate %v", payload.ID)
+	if _, err := config.API.Activate(config.StopContext, payload); err != nil {
+		return fmt.Errorf("activation error: %v", err)
 	}
 	return nil
 }
 
 // all errors of deactivation are silenced
 func onPipelineHelmDelete(d *schema.ResourceData, meta interface{}) error {
-	apiRoot := meta.(*providerConfig).APIRoot
+	config := meta.(*providerConfig)
 	if len(d.Id()) == 0 {
 		return nil
 	}
-	Secret := SafeString(d, "secret")
-	payload := PipelineRef{ID: d.Id(), Secret: Secret}
+	ref := pipelinesapi.Ref{ID: d.Id(), Secret: SafeString(d, "secret")}
 
-	log.Printf("onPipelineHelmDelete deactivate %v", payload)
-	body, _ := json.Marshal(&payload)
-	resp, err := http.Post(apiRoot+"/api/pipelines/deactivate", "application/json", bytes.NewBuffer(body))
-	if err != nil {
-		log.Printf("[ERROR] silenced: %v, payload %v", err, payload)
-		return nil
-	}
-	defer resp.Body.Close()
-	buf, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return err
-	}
-	if resp.StatusCode >= 300 {
-		log.Printf("[ERROR] silenced: status=%d, %v, payload %v", resp.StatusCode, string(buf), payload)
+	log.Printf("onPipelineHelmDelete deactivate %v", ref.ID)
+	if err := config.API.Deactivate(config.StopContext, ref); err != nil {
+		log.Printf("[ERROR] silenced: %v, pipeline %v", err, ref.ID)
 		return nil
 	}
 	log.Println("onPipelineHelmDelete: done")
 	return nil
 }
+
+func pipelineHelmPayload(d *schema.ResourceData) *pipelinesapi.Pipeline {
+	return &pipelinesapi.Pipeline{
+		Origin:           SafeString(d, "origin"),
+		RegistryURL:      SafeString(d, "registry_url"),
+		RegistryProvider: SafeString(d, "registry_provider"),
+		// helm-specific
+		Type:      pipelinesapi.KindHelm,
+		Archive:   SafeString(d, "archive"),
+		Release:   SafeString(d, "release"),
+		Namespace: SafeString(d, "namespace"),
+		Branches:  SafeStringList(d, "branches"),
+		Approvals: approvalsPayload(d),
+	}
+}
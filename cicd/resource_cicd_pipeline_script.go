@@ -5,7 +5,11 @@
 package cicd
 
 import (
+	"fmt"
+	"log"
+
 	"github.com/AtlantPlatform/terraform-provider-cicd/internal/helpers"
+	"github.com/AtlantPlatform/terraform-provider-cicd/internal/pipelinesapi"
 	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
 )
 
@@ -22,7 +26,9 @@ func resourcePipelineScript() *schema.Resource {
 
 		SchemaVersion: 1,
 
-		Schema: map[string]*schema.Schema{
+		CustomizeDiff: validateApprovals,
+
+		Schema: withApprovals(map[string]*schema.Schema{
 			"exec": {
 				Type:        schema.TypeString,
 				Required:    true,
@@ -41,31 +47,99 @@ func resourcePipelineScript() *schema.Resource {
 				Optional:    true,
 				Description: "(optional) environment variables for execution",
 			},
-			// TODO: approvals
 			"secret": {
 				Type:        schema.TypeString,
 				Computed:    true,
 				Description: "pipeline secret to use this pipeline",
 			},
-		},
+		}),
 	}
 }
 
-func onPipelineScriptCreate(d *schema.ResourceData, m interface{}) error {
-	ID := helpers.NewRandSeq(8)
-	d.SetId(ID)
-	d.Set("secret", helpers.NewRandSeq(32))
+func onPipelineScriptCreate(d *schema.ResourceData, meta interface{}) error {
+	config := meta.(*providerConfig)
+	if err := checkApprovals(d); err != nil {
+		return err
+	}
+	payload := pipelineScriptPayload(d)
+	payload.ID = helpers.NewRandSeq(32)
+
+	log.Printf("onPipelineScriptCreate activate %v", payload.ID)
+	out, err := config.API.Activate(config.StopContext, payload)
+	if err != nil {
+		return fmt.Errorf("activation error: %v", err)
+	}
+	d.SetId(out.ID)
+	// secret comes back from pipelines server
+	d.Set("secret", out.Secret)
 	return nil
 }
 
-func onPipelineScriptRead(d *schema.ResourceData, m interface{}) error {
+func onPipelineScriptRead(d *schema.ResourceData, meta interface{}) error {
+	config := meta.(*providerConfig)
+	if len(d.Id()) == 0 {
+		return nil
+	}
+	ref := pipelinesapi.Ref{ID: d.Id(), Secret: SafeString(d, "secret")}
+	remote, err := config.API.Get(config.StopContext, ref)
+	if pipelinesapi.IsNotFound(err) {
+		log.Printf("[WARN] pipeline %v is gone, removing from state", ref.ID)
+		d.SetId("")
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("pipeline read error: %v", err)
+	}
+
+	d.Set("exec", remote.Exec)
+	d.Set("plan", remote.Plan)
+	d.Set("env", remote.Env)
+	setApprovals(d, remote.Approvals)
 	return nil
 }
 
-func onPipelineScriptUpdate(d *schema.ResourceData, m interface{}) error {
+func onPipelineScriptUpdate(d *schema.ResourceData, meta interface{}) error {
+	config := meta.(*providerConfig)
+	if len(d.Id()) == 0 {
+		return nil
+	}
+	if err := checkApprovals(d); err != nil {
+		return err
+	}
+	payload := pipelineScriptPayload(d)
+	payload.ID = d.Id()
+	payload.Secret = SafeString(d, "secret")
+
+	log.Printf("onPipelineScriptUpdate activate %v", payload.ID)
+	if _, err := config.API.Activate(config.StopContext, payload); err != nil {
+		return fmt.Errorf("activation error: %v", err)
+	}
 	return nil
 }
 
-func onPipelineScriptDelete(d *schema.ResourceData, m interface{}) error {
+// all errors of deactivation are silenced
+func onPipelineScriptDelete(d *schema.ResourceData, meta interface{}) error {
+	config := meta.(*providerConfig)
+	if len(d.Id()) == 0 {
+		return nil
+	}
+	ref := pipelinesapi.Ref{ID: d.Id(), Secret: SafeString(d, "secret")}
+
+	log.Printf("onPipelineScriptDelete deactivate %v", ref.ID)
+	if err := config.API.Deactivate(config.StopContext, ref); err != nil {
+		log.Printf("[ERROR] silenced: %v, pipeline %v", err, ref.ID)
+		return nil
+	}
+	log.Println("onPipelineScriptDelete: done")
 	return nil
 }
+
+func pipelineScriptPayload(d *schema.ResourceData) *pipelinesapi.Pipeline {
+	return &pipelinesapi.Pipeline{
+		Type: pipelinesapi.KindScript,
+		Exec: SafeString(d, "exec"),
+		Plan: SafeString(d, "plan"),
+		Env:  SafeStringMap(d, "env"),
+
+		Approvals: approvalsPayload(d),
+	}
+}
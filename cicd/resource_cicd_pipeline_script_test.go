@@ -0,0 +1,69 @@
+// Copyright 2017-2021 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package cicd
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/AtlantPlatform/terraform-provider-cicd/internal/pipelinesapi"
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPipelineScript_Lifecycle(t *testing.T) {
+	r := require.New(t)
+	srv := newPipelinesServer(t)
+	res := resourcePipelineScript()
+	d := schema.TestResourceDataRaw(t, res.Schema, map[string]interface{}{
+		"exec": "make deploy",
+		"plan": "make plan",
+		"env":  map[string]interface{}{"STAGE": "prod"},
+	})
+
+	r.NoError(res.Create(d, srv.config()))
+	r.Len(srv.activated, 1)
+	sent := srv.activated[0]
+	r.Equal(d.Id(), sent.ID)
+	r.Equal(pipelinesapi.KindScript, sent.Type)
+	r.Equal("make deploy", sent.Exec)
+	r.Equal("make plan", sent.Plan)
+	r.Equal(map[string]string{"STAGE": "prod"}, sent.Env)
+	r.Equal("s3cr3t", d.Get("secret"))
+
+	// command is updated in place, keeping the pipeline
+	r.NoError(d.Set("exec", "make release"))
+	r.NoError(res.Update(d, srv.config()))
+	r.Len(srv.activated, 2)
+	r.Equal(d.Id(), srv.activated[1].ID)
+	r.Equal("s3cr3t", srv.activated[1].Secret)
+	r.Equal("make release", srv.activated[1].Exec)
+
+	srv.pipeline = pipelinesapi.Pipeline{ID: d.Id(), Exec: "make other"}
+	r.NoError(res.Read(d, srv.config()))
+	r.Equal("make other", d.Get("exec"))
+
+	r.NoError(res.Delete(d, srv.config()))
+	r.Equal([]pipelinesapi.Ref{{ID: d.Id(), Secret: "s3cr3t"}}, srv.deactivated)
+}
+
+func TestPipelineScript_ReadGone(t *testing.T) {
+	for _, status := range []int{http.StatusNotFound, http.StatusGone} {
+		srv := newPipelinesServer(t)
+		srv.status = status
+		d := resourcePipelineScript().TestResourceData()
+		d.SetId("abc")
+		require.NoError(t, resourcePipelineScript().Read(d, srv.config()))
+		require.Equal(t, "", d.Id(), status)
+	}
+}
+
+func TestPipelineScript_DeleteSilenced(t *testing.T) {
+	srv := newPipelinesServer(t)
+	srv.status = http.StatusBadGateway
+	d := resourcePipelineScript().TestResourceData()
+	d.SetId("abc")
+	require.NoError(t, resourcePipelineScript().Delete(d, srv.config()))
+}
@@ -0,0 +1,121 @@
+// Copyright 2017-2021 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package cicd
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/AtlantPlatform/terraform-provider-cicd/internal/pipelinesapi"
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"github.com/stretchr/testify/require"
+)
+
+// pipelinesServer is a stand-in of the pipelines server
+type pipelinesServer struct {
+	*httptest.Server
+
+	mu          sync.Mutex
+	activated   []pipelinesapi.Pipeline
+	deactivated []pipelinesapi.Ref
+	// pipeline is returned by GET
+	pipeline pipelinesapi.Pipeline
+	// status of GET and deactivation responses, when set
+	status int
+}
+
+func newPipelinesServer(t *testing.T) *pipelinesServer {
+	s := &pipelinesServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		switch {
+		case req.URL.Path == "/api/pipelines/activate":
+			var in pipelinesapi.Pipeline
+			json.NewDecoder(req.Body).Decode(&in)
+			s.activated = append(s.activated, in)
+			json.NewEncoder(w).Encode(&pipelinesapi.ActivateResponse{ID: in.ID, Secret: "s3cr3t"})
+		case s.status != 0:
+			w.WriteHeader(s.status)
+		case req.URL.Path == "/api/pipelines/deactivate":
+			var ref pipelinesapi.Ref
+			json.NewDecoder(req.Body).Decode(&ref)
+			s.deactivated = append(s.deactivated, ref)
+		case strings.HasPrefix(req.URL.Path, "/api/pipelines/"):
+			json.NewEncoder(w).Encode(&s.pipeline)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// config is provider configuration using the server
+func (s *pipelinesServer) config() *providerConfig {
+	return &providerConfig{
+		API:         pipelinesapi.New(s.URL, s.Client()),
+		StopContext: context.Background(),
+	}
+}
+
+func TestPipelineTerraform_Lifecycle(t *testing.T) {
+	r := require.New(t)
+	srv := newPipelinesServer(t)
+	res := resourcePipelineTerraform()
+	d := schema.TestResourceDataRaw(t, res.Schema, map[string]interface{}{
+		"archive":            "plans/web.zip",
+		"values":             map[string]interface{}{"env": "prod"},
+		"approvals_required": 1,
+		"approvers":          []interface{}{"alice"},
+	})
+
+	r.NoError(res.Create(d, srv.config()))
+	r.Len(srv.activated, 1)
+	sent := srv.activated[0]
+	r.Equal(d.Id(), sent.ID)
+	r.Equal(pipelinesapi.KindTerraform, sent.Type)
+	r.Equal("plans/web.zip", sent.Archive)
+	r.Equal(map[string]string{"env": "prod"}, sent.Values)
+	r.Equal(pipelinesapi.Approvals{ApprovalsRequired: 1, Approvers: []string{"alice"}}, sent.Approvals)
+	r.Equal("s3cr3t", d.Get("secret"))
+
+	// drift on the server is refreshed into the state
+	srv.pipeline = pipelinesapi.Pipeline{ID: d.Id(), Archive: "plans/web-2.zip"}
+	r.NoError(res.Read(d, srv.config()))
+	r.Equal("plans/web-2.zip", d.Get("archive"))
+
+	r.NoError(res.Update(d, srv.config()))
+	r.Len(srv.activated, 2)
+	r.Equal(d.Id(), srv.activated[1].ID)
+	r.Equal("s3cr3t", srv.activated[1].Secret)
+
+	r.NoError(res.Delete(d, srv.config()))
+	r.Equal([]pipelinesapi.Ref{{ID: d.Id(), Secret: "s3cr3t"}}, srv.deactivated)
+}
+
+func TestPipelineTerraform_ReadGone(t *testing.T) {
+	for _, status := range []int{http.StatusNotFound, http.StatusGone} {
+		srv := newPipelinesServer(t)
+		srv.status = status
+		d := resourcePipelineTerraform().TestResourceData()
+		d.SetId("abc")
+		require.NoError(t, resourcePipelineTerraform().Read(d, srv.config()))
+		require.Equal(t, "", d.Id(), status)
+	}
+}
+
+func TestPipelineTerraform_DeleteSilenced(t *testing.T) {
+	srv := newPipelinesServer(t)
+	srv.status = http.StatusInternalServerError
+	d := resourcePipelineTerraform().TestResourceData()
+	d.SetId("abc")
+	require.NoError(t, resourcePipelineTerraform().Delete(d, srv.config()))
+}
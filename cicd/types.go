@@ -5,8 +5,11 @@
 package cicd
 
 import (
+	"context"
 	"fmt"
+	"net/http"
 
+	"github.com/AtlantPlatform/terraform-provider-cicd/internal/pipelinesapi"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
 )
@@ -18,58 +21,17 @@ type providerConfig struct {
 	AwsProfile string
 	AwsRegion  string
 	Session    *session.Session
-}
-
-// PipelineKind embeds type of the pipeline
-type PipelineKind string
-
-const (
-	// PipelineKindHelm is HELM pipeline
-	PipelineKindHelm PipelineKind = "helm"
-	// PipelineKindTerraform is Terraform pipeline
-	PipelineKindTerraform = "terraform"
-	// PipelineKindScript is Script pipeline
-	PipelineKindScript = "script"
-)
-
-// PipelineRef is a secure reference to the pipeline
-// (requests without secret matching will not work)
-type PipelineRef struct {
-	ID     string `json:"id"`
-	Secret string `json:"secret"`
-}
-
-// PipelineActivateResponse is a response to pipeline activation
-type PipelineActivateResponse struct {
-	ID     string `json:"id"`
-	Secret string `json:"secret"`
-}
-
-// PipelineHelmCreate is a structure for HELM pipeline creation updat
-type PipelineHelmCreate struct {
-	ID string `json:"id"`
-	// Secret (required for updates)
-	Secret string `json:"secret"`
-	// Kind of the pipeline: Helm
-	Type PipelineKind `json:"type"`
-	// GIT origin to be checked
-	Origin string `json:"origin,omitempty"`
-	// GIT branches to be checked
-	Branches []string `json:"branches,omitempty"`
-	// Container registry for docker images
-	RegistryURL string `json:"registry_url,omitempty"`
-	// Container registrry provider
-	RegistryProvider string `json:"registry_provider,omitempty"`
-	// only for HELM: Chart ZIP archive location on S3 Bucket
-	Archive string `json:"archive,omitempty"`
-	// only for HELM: release name. should be Taken from chart.yaml if not speficied
-	Release string `json:"release,omitempty"`
-	// Chart release namespace. If not specified, 'default' will be used
-	Namespace string `json:"namespace,omitempty"`
-	// Number of approves required for the pipeline
-	ApprovalsRequired int `json:"approvesrequired"`
-	// List of approvers who can approve pipeline
-	Approvers []string `json:"approvers"`
+	// HTTPClient is shared by all requests to the pipelines server
+	HTTPClient *http.Client
+	// StorageHTTPClient uploads charts to http storage. It trusts system CAs and
+	// has no timeout, as pipelines API settings don't apply to third-party servers
+	StorageHTTPClient *http.Client
+	// RegistryHTTPClient pushes charts to OCI registries, configured like StorageHTTPClient
+	RegistryHTTPClient *http.Client
+	// API is a client of the pipelines server
+	API *pipelinesapi.Client
+	// StopContext is cancelled when terraform interrupts the provider
+	StopContext context.Context
 }
 
 func SafeStringList(d *schema.ResourceData, field string) []string {
@@ -98,3 +60,16 @@ func SafeNum(d *schema.ResourceData, field string) int {
 	}
 	return d.Get(field).(int)
 }
+
+func SafeStringMap(d *schema.ResourceData, field string) map[string]string {
+	res := map[string]string{}
+	if d == nil {
+		return res
+	}
+	if d.Get(field) != nil {
+		for k, v := range d.Get(field).(map[string]interface{}) {
+			res[k] = v.(string)
+		}
+	}
+	return res
+}
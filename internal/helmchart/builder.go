@@ -6,41 +6,51 @@ package helmchart
 
 import (
 	"archive/zip"
-	"bytes"
-
 	"fmt"
 	"io"
 	"io/ioutil"
+	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/AtlantPlatform/terraform-provider-cicd/internal/dirhash"
-	"github.com/AtlantPlatform/terraform-provider-cicd/internal/helpers"
 	"gopkg.in/yaml.v2"
 )
 
 type Declaration struct {
-	ApiVersion  string `yaml:"apiVersion"`
-	AppVersion  string `yaml:"appVersion"`
-	Description string `yaml:"description"`
-	Name        string `yaml:"name"`
-	Version     string `yaml:"version"`
+	ApiVersion  string `yaml:"apiVersion" json:"apiVersion"`
+	AppVersion  string `yaml:"appVersion" json:"appVersion,omitempty"`
+	Description string `yaml:"description" json:"description,omitempty"`
+	Name        string `yaml:"name" json:"name"`
+	Version     string `yaml:"version" json:"version"`
 }
 
+// ModTime is modification time of all archive entries, for reproducible archives.
+// It is the earliest time ZIP format can represent
+var ModTime = time.Date(1980, time.January, 1, 0, 0, 0, 0, time.UTC)
+
 type Builder struct {
-	ID   string
-	Hash string
-	Name string
+	ID      string
+	Hash    string
+	Name    string
+	Version string
 
-	source      string
-	yamlChart   string
-	yamlValues  string
-	txtOverride string
-	txtAllowed  string
+	source string
+	decl   Declaration
+	// overrides are merged over values on installation
+	overrides map[string]interface{}
+	// files shipped with the chart, relative to source
+	files        []string
+	yamlOverride string
+	txtAllowed   string
 }
 
-func New(source string, args map[string]interface{}, allowed []string) (*Builder, error) {
+// New reads HELM chart from the source folder.
+// Overrides (see ParseOverrides) are shipped to be merged over values.yaml
+func New(source string, overrides map[string]interface{}, allowed []string) (*Builder, error) {
 	if _, err := os.Stat(source); err != nil {
 		return nil, fmt.Errorf("%s path error", err)
 	} else if _, err := os.Stat(source + "/values.yaml"); os.IsNotExist(err) {
@@ -60,35 +70,61 @@ func New(source string, args map[string]interface{}, allowed []string) (*Builder
 		return nil, fmt.Errorf("%s/Chart.yaml parse failure %v", source, err)
 	}
 
-	yamlValuesFile, err := ioutil.ReadFile(fmt.Sprintf("%s/values.yaml", source))
+	// values.yaml must parse
+	if _, err := LoadValues(source); err != nil {
+		return nil, err
+	}
+	// keys of maps are sorted on serialization, so override.yaml is stable
+	yamlOverride, err := yaml.Marshal(overrides)
 	if err != nil {
-		return nil, fmt.Errorf("%s/values.yaml read failure %v", source, err)
+		return nil, fmt.Errorf("overrides serialization failure %v", err)
 	}
-	var t interface{}
-	if err := yaml.Unmarshal(yamlValuesFile, &t); err != nil {
-		return nil, fmt.Errorf("%s/values.yaml parse failure %v", source, err)
+	rules, err := readIgnore(source)
+	if err != nil {
+		return nil, err
 	}
-	arrOverride := make([]string, 0)
-	if args != nil {
-		for k, v := range args {
-			arrOverride = append(arrOverride, fmt.Sprintf("--set %s='%s'", k, v))
-		}
+	s := &Builder{
+		Name:         decl.Name,
+		Version:      decl.Version,
+		source:       source,
+		decl:         *decl,
+		overrides:    overrides,
+		yamlOverride: string(yamlOverride),
+		txtAllowed:   strings.Join(allowed, "\n"),
 	}
-	hash, err := dirhash.HashDir(source, "", dirhash.Hash1)
-	if err != nil {
+	if s.files, err = listFiles(source, rules, s.generated()); err != nil {
+		return nil, fmt.Errorf("source %v read failure %v", source, err)
+	}
+	if s.Hash, err = s.hash(); err != nil {
 		return nil, fmt.Errorf("source %v hash failure %v", source, err)
 	}
-	id := hash[0:12]
-	return &Builder{
-		Name:        decl.Name,
-		ID:          id,
-		Hash:        hash,
-		source:      source,
-		yamlChart:   string(yamlChartFile),
-		yamlValues:  string(yamlValuesFile),
-		txtOverride: strings.Join(arrOverride, " "),
-		txtAllowed:  strings.Join(allowed, "\n"),
-	}, nil
+	s.ID = s.Hash[0:12]
+	return s, nil
+}
+
+// generated are archive entries produced from the resource arguments
+func (s *Builder) generated() []zipFile {
+	return []zipFile{
+		{Name: OverrideFile, Body: s.yamlOverride},
+		{Name: "allowed.txt", Body: s.txtAllowed},
+	}
+}
+
+// hash covers every entry of the archive: chart files and generated ones,
+// so the chart ID changes whenever any shipped byte changes
+func (s *Builder) hash() (string, error) {
+	bodies := map[string]string{}
+	names := append([]string{}, s.files...)
+	for _, file := range s.generated() {
+		bodies[file.Name] = file.Body
+		names = append(names, file.Name)
+	}
+	return dirhash.Hash1(names, func(name string) (io.ReadCloser, error) {
+		if body, ok := bodies[name]; ok {
+			return ioutil.NopCloser(strings.NewReader(body)), nil
+		}
+		return os.Open(filepath.Join(s.source, filepath.FromSlash(name)))
+	})
 }
 
 func (s *Builder) GetZipName() string {
@@ -101,6 +137,8 @@ func (s *Builder) GetZipName() string {
 type zipFile struct {
 	Name string
 	Body string
+	// path of the chart file with the content, if Body is not used
+	path string
 }
 
 func (s *Builder) GetHash() string {
@@ -111,52 +149,120 @@ func (s *Builder) GetID() string {
 	return s.Hash
 }
 
-func (s *Builder) ZIP() (io.ReadSeeker, error) {
-	// Create a buffer to write our archive to.
-	buf := new(bytes.Buffer)
-	// Create a new zip archive.
-	w := zip.NewWriter(buf)
-	// Add some files to the archive.
-	var files = []zipFile{
-		{"values.yaml", s.yamlValues},
-		{"override.txt", s.txtOverride},
-		{"allowed.txt", s.txtAllowed},
-		{"Chart.yaml", s.yamlChart},
-	}
-	// read files under templates/ folder
-	err := filepath.Walk(s.source+"/templates", func(path string, info os.FileInfo, err error) error {
-		if info.IsDir() {
+// listFiles returns relative slash-separated paths of the files shipped with the chart:
+// every file of the source folder not excluded by .helmignore, like helm packages it.
+// Files in the chart root named as generated entries are replaced by them
+func listFiles(source string, rules ignoreRules, generated []zipFile) ([]string, error) {
+	reserved := map[string]bool{}
+	for _, file := range generated {
+		reserved[file.Name] = true
+	}
+	files := []string{}
+	err := filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(source, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if info.Mode()&os.ModeSymlink != 0 {
+			// helm follows links to files
+			if info, err = os.Stat(path); err != nil {
+				return fmt.Errorf("%s link error %v", path, err)
+			}
+		}
+		if rules.Ignored(rel, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
 			return nil
 		}
-		fmt.Printf("reading %v\n", info.Name())
-		yamlFile, err := ioutil.ReadFile(path)
-		if err != nil {
-			return fmt.Errorf("%s/%s read failure %v", s.source, path, err)
+		switch {
+		case info.IsDir() || !info.Mode().IsRegular():
+		case reserved[rel]:
+			log.Printf("[WARN] %s is not shipped, the archive entry is generated", path)
+		default:
+			files = append(files, rel)
 		}
-		files = append(files, zipFile{
-			Name: "templates/" + info.Name(),
-			Body: string(yamlFile),
-		})
 		return nil
 	})
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
+	return files, nil
+}
+
+// entries lists content of the archive sorted by name, so archive is reproducible.
+// Chart files are not read, but streamed on writing
+func (s *Builder) entries(generated bool) ([]zipFile, error) {
+	files := make([]zipFile, 0, len(s.files)+2)
+	if generated {
+		files = append(files, s.generated()...)
+	}
+	for _, name := range s.files {
+		files = append(files, zipFile{Name: name, path: filepath.Join(s.source, filepath.FromSlash(name))})
+	}
+	sort.SliceStable(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	for i := 1; i < len(files); i++ {
+		if files[i].Name == files[i-1].Name {
+			return nil, fmt.Errorf("%s: duplicate archive entry %s", s.source, files[i].Name)
+		}
+	}
+	return files, nil
+}
 
+// size of the entry content
+func (f zipFile) size() (int64, error) {
+	if f.path == "" {
+		return int64(len(f.Body)), nil
+	}
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return 0, fmt.Errorf("%s path error %v", f.path, err)
+	}
+	return info.Size(), nil
+}
+
+// copyTo writes the entry content, chart files are copied from the disk
+func (f zipFile) copyTo(w io.Writer) error {
+	if f.path == "" {
+		_, err := io.WriteString(w, f.Body)
+		return err
+	}
+	log.Printf("[DEBUG] reading %v", f.Name)
+	file, err := os.Open(f.path)
+	if err != nil {
+		return fmt.Errorf("%s read failure %v", f.path, err)
+	}
+	defer file.Close()
+	if _, err := io.Copy(w, file); err != nil {
+		return fmt.Errorf("%s read failure %v", f.path, err)
+	}
+	return nil
+}
+
+// WriteZIP streams archive for the pipelines server to out
+func (s *Builder) WriteZIP(out io.Writer) error {
+	files, err := s.entries(true)
+	if err != nil {
+		return err
+	}
+	w := zip.NewWriter(out)
 	for _, file := range files {
-		f, err := w.Create(file.Name)
+		f, err := w.CreateHeader(&zip.FileHeader{
+			Name:     file.Name,
+			Method:   zip.Deflate,
+			Modified: ModTime,
+		})
 		if err != nil {
-			return nil, err
+			return err
 		}
-		_, err = f.Write([]byte(file.Body))
-		if err != nil {
-			return nil, err
+		if err := file.copyTo(f); err != nil {
+			return err
 		}
 	}
-
 	// Make sure to check the error on Close.
-	if errClose := w.Close(); errClose != nil {
-		return nil, err
-	}
-	return helpers.NewReadSeeker(buf.Bytes()), nil
+	return w.Close()
 }
@@ -0,0 +1,97 @@
+// Copyright 2017-2021 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package helmchart
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// HelmIgnore is the name of the file with chart ignore rules
+const HelmIgnore = ".helmignore"
+
+// defaultIgnore is always applied, like helm does (hidden files in templates)
+var defaultIgnore = []string{"templates/.?*"}
+
+type ignoreRule struct {
+	pattern string
+	negate  bool
+	dirOnly bool
+	// rule without slash is matched against base name on any depth
+	baseName bool
+}
+
+// ignoreRules are .helmignore rules: shell glob patterns, one per line,
+// '#' comments, '!' negation and trailing '/' for folders only.
+// The last matching rule wins
+type ignoreRules []ignoreRule
+
+func parseIgnore(body []byte) (ignoreRules, error) {
+	var rules ignoreRules
+	lines := append([]string{}, defaultIgnore...)
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule := ignoreRule{}
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+		line = strings.TrimPrefix(line, "/")
+		rule.baseName = !strings.Contains(line, "/")
+		if _, err := path.Match(line, ""); err != nil {
+			return nil, fmt.Errorf("%s: bad pattern %q: %v", HelmIgnore, line, err)
+		}
+		rule.pattern = line
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// readIgnore reads .helmignore of the chart source (if present)
+func readIgnore(source string) (ignoreRules, error) {
+	body, err := ioutil.ReadFile(filepath.Join(source, HelmIgnore))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("%s/%s read failure %v", source, HelmIgnore, err)
+	}
+	return parseIgnore(body)
+}
+
+// Ignored reports whether file or folder on the relative slash-separated path is ignored
+func (r ignoreRules) Ignored(rel string, isDir bool) bool {
+	ignored := false
+	for _, rule := range r {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		name := rel
+		if rule.baseName {
+			name = path.Base(rel)
+		}
+		if ok, _ := path.Match(rule.pattern, name); ok {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
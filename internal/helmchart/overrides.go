@@ -0,0 +1,191 @@
+// Copyright 2017-2021 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package helmchart
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// OverrideFile is the document with values to be merged over values.yaml
+const OverrideFile = "override.yaml"
+
+// ParseOverrides builds nested overrides from YAML (or JSON) document
+// and flat arguments with dotted keys ("image.tag"), arguments take precedence.
+// Dots in keys could be escaped with backslash ("kubernetes\.io/ingress\.class").
+// String arguments are typed like helm --set does, see TypedValue
+func ParseOverrides(doc string, args map[string]interface{}) (map[string]interface{}, error) {
+	res := map[string]interface{}{}
+	if strings.TrimSpace(doc) != "" {
+		var parsed interface{}
+		if err := yaml.Unmarshal([]byte(doc), &parsed); err != nil {
+			return nil, fmt.Errorf("overrides parse failure %v", err)
+		}
+		m, ok := normalize(parsed).(map[string]interface{})
+		if !ok && parsed != nil {
+			return nil, fmt.Errorf("overrides must be a map, found %T", parsed)
+		}
+		if m != nil {
+			res = m
+		}
+	}
+	keys := make([]string, 0, len(args))
+	for k := range args {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		value := args[k]
+		if s, ok := value.(string); ok {
+			value = TypedValue(s)
+		}
+		if err := setPath(res, SplitPath(k), value); err != nil {
+			return nil, fmt.Errorf("argument %s: %v", k, err)
+		}
+	}
+	return res, nil
+}
+
+// TypedValue converts argument the way helm --set does: true/false to bool,
+// null to nil (removing the key from values), integers without leading zero to int64.
+// Anything else stays string, use overrides document to pass e.g. "true" as string
+func TypedValue(s string) interface{} {
+	switch {
+	case strings.EqualFold(s, "true"):
+		return true
+	case strings.EqualFold(s, "false"):
+		return false
+	case strings.EqualFold(s, "null"):
+		return nil
+	case s == "0":
+		return int64(0)
+	}
+	if s != "" && s[0] != '0' {
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return i
+		}
+	}
+	return s
+}
+
+// SplitPath splits dotted key into path segments, honoring escaped dots
+func SplitPath(key string) []string {
+	var path []string
+	var cur strings.Builder
+	for i := 0; i < len(key); i++ {
+		switch {
+		case key[i] == '\\' && i+1 < len(key) && key[i+1] == '.':
+			cur.WriteByte('.')
+			i++
+		case key[i] == '.':
+			path = append(path, cur.String())
+			cur.Reset()
+		default:
+			cur.WriteByte(key[i])
+		}
+	}
+	return append(path, cur.String())
+}
+
+// JoinPath is reverse of SplitPath
+func JoinPath(path []string) string {
+	escaped := make([]string, len(path))
+	for i, p := range path {
+		escaped[i] = strings.Replace(p, ".", "\\.", -1)
+	}
+	return strings.Join(escaped, ".")
+}
+
+func setPath(m map[string]interface{}, path []string, value interface{}) error {
+	for i, p := range path[:len(path)-1] {
+		next, ok := m[p]
+		if !ok {
+			child := map[string]interface{}{}
+			m[p] = child
+			m = child
+			continue
+		}
+		child, ok := next.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s is not a map", JoinPath(path[:i+1]))
+		}
+		m = child
+	}
+	m[path[len(path)-1]] = value
+	return nil
+}
+
+// Paths returns dotted paths of all leaf values of the overrides, sorted
+func Paths(overrides map[string]interface{}) []string {
+	var res []string
+	var walk func(prefix []string, m map[string]interface{})
+	walk = func(prefix []string, m map[string]interface{}) {
+		for k, v := range m {
+			path := append(append([]string{}, prefix...), k)
+			if child, ok := v.(map[string]interface{}); ok && len(child) > 0 {
+				walk(path, child)
+				continue
+			}
+			res = append(res, JoinPath(path))
+		}
+	}
+	walk(nil, overrides)
+	sort.Strings(res)
+	return res
+}
+
+// CheckAllowed verifies that every override is permitted by allowed list:
+// either listed itself or nested under listed key. Empty list allows everything
+func CheckAllowed(overrides map[string]interface{}, allowed []string) error {
+	if len(allowed) == 0 {
+		return nil
+	}
+	var denied []string
+	for _, path := range Paths(overrides) {
+		if !isAllowed(path, allowed) {
+			denied = append(denied, path)
+		}
+	}
+	if len(denied) > 0 {
+		return fmt.Errorf("overrides not in allowed list: %s", strings.Join(denied, ", "))
+	}
+	return nil
+}
+
+func isAllowed(path string, allowed []string) bool {
+	for _, a := range allowed {
+		if path == a || strings.HasPrefix(path, a+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// normalize converts maps decoded by yaml.v2 into map[string]interface{}
+func normalize(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, val := range t {
+			m[fmt.Sprintf("%v", k)] = normalize(val)
+		}
+		return m
+	case map[string]interface{}:
+		for k, val := range t {
+			t[k] = normalize(val)
+		}
+		return t
+	case []interface{}:
+		for i, val := range t {
+			t[i] = normalize(val)
+		}
+		return t
+	}
+	return v
+}
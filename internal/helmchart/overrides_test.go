@@ -0,0 +1,99 @@
+// Copyright 2017-2021 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package helmchart
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseOverrides(t *testing.T) {
+	r := require.New(t)
+	overrides, err := ParseOverrides(`
+replicas: 3
+debug: true
+hosts: [a.example.com, b.example.com]
+image:
+  repository: web
+`, map[string]interface{}{
+		"image.tag": "it's, a.tag",
+		"ingress.annotations.kubernetes\\.io/ingress\\.class": "nginx",
+	})
+	r.NoError(err)
+	r.Equal(map[string]interface{}{
+		"replicas": 3,
+		"debug":    true,
+		"hosts":    []interface{}{"a.example.com", "b.example.com"},
+		"image": map[string]interface{}{
+			"repository": "web",
+			"tag":        "it's, a.tag",
+		},
+		"ingress": map[string]interface{}{
+			"annotations": map[string]interface{}{
+				"kubernetes.io/ingress.class": "nginx",
+			},
+		},
+	}, overrides)
+	r.Equal([]string{
+		"debug",
+		"hosts",
+		"image.repository",
+		"image.tag",
+		"ingress.annotations.kubernetes\\.io/ingress\\.class",
+		"replicas",
+	}, Paths(overrides))
+
+	_, err = ParseOverrides("replicas: 3", map[string]interface{}{"replicas.max": "5"})
+	r.Error(err)
+	_, err = ParseOverrides("- a", nil)
+	r.Error(err)
+}
+
+func TestParseOverrides_TypedArgs(t *testing.T) {
+	r := require.New(t)
+	overrides, err := ParseOverrides("debug: true\nport: 80\n", map[string]interface{}{
+		"replicas":  "3",
+		"port":      "null",
+		"debug":     "False",
+		"enabled":   "TRUE",
+		"zero":      "0",
+		"octal":     "0644",
+		"negative":  "-1",
+		"version":   "1.10",
+		"image.tag": "v1",
+		"huge":      "99999999999999999999",
+		"empty":     "",
+	})
+	r.NoError(err)
+	r.Equal(map[string]interface{}{
+		"replicas": int64(3),
+		"port":     nil,
+		"debug":    false,
+		"enabled":  true,
+		"zero":     int64(0),
+		"octal":    "0644",
+		"negative": int64(-1),
+		"version":  "1.10",
+		"image":    map[string]interface{}{"tag": "v1"},
+		"huge":     "99999999999999999999",
+		"empty":    "",
+	}, overrides)
+
+	// null removes the value, like helm does
+	r.Equal(map[string]interface{}{"debug": false}, MergeValues(
+		map[string]interface{}{"debug": true, "port": 80}, map[string]interface{}{"debug": false, "port": nil},
+	))
+}
+
+func TestCheckAllowed(t *testing.T) {
+	r := require.New(t)
+	overrides, err := ParseOverrides("image: {tag: v1}\nreplicas: 2", nil)
+	r.NoError(err)
+	r.NoError(CheckAllowed(overrides, nil))
+	r.NoError(CheckAllowed(overrides, []string{"image", "replicas"}))
+	r.EqualError(CheckAllowed(overrides, []string{"image.tag"}),
+		"overrides not in allowed list: replicas")
+}
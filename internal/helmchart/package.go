@@ -0,0 +1,101 @@
+// Copyright 2017-2021 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package helmchart
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+const (
+	// FormatZIP is ZIP archive for the pipelines server,
+	// with override.yaml (OverrideFile) and allowed.txt
+	FormatZIP = "zip"
+	// FormatTGZ is standard HELM v3 chart package
+	FormatTGZ = "tgz"
+)
+
+const (
+	// ConfigMediaType is media type of chart metadata in OCI registry
+	ConfigMediaType = "application/vnd.cncf.helm.config.v1+json"
+	// ChartLayerMediaType is media type of chart package in OCI registry
+	ChartLayerMediaType = "application/vnd.cncf.helm.chart.content.v1.tar+gzip"
+)
+
+// GetDeclaration returns chart metadata from Chart.yaml
+func (s *Builder) GetDeclaration() Declaration {
+	return s.decl
+}
+
+// ConfigJSON returns chart metadata for OCI registry config
+func (s *Builder) ConfigJSON() ([]byte, error) {
+	return json.Marshal(&s.decl)
+}
+
+// GetTgzName returns location of HELM chart package.
+// File name follows helm convention, while folder keeps location unique for chart content
+func (s *Builder) GetTgzName() string {
+	if s.ID == "" || s.Name == "" || s.Version == "" {
+		return ""
+	}
+	return "helm/" + s.ID + "/" + s.Name + "-" + s.Version + ".tgz"
+}
+
+// GetArchiveName returns location of the archive of the given format
+func (s *Builder) GetArchiveName(format string) string {
+	if format == FormatTGZ {
+		return s.GetTgzName()
+	}
+	return s.GetZipName()
+}
+
+// WriteArchive streams archive of the given format to out, returning its content type
+func (s *Builder) WriteArchive(out io.Writer, format string) (string, error) {
+	switch format {
+	case FormatTGZ:
+		return "application/gzip", s.WriteTGZ(out)
+	case FormatZIP, "":
+		return "application/zip", s.WriteZIP(out)
+	}
+	return "", fmt.Errorf("unknown archive format %s", format)
+}
+
+// WriteTGZ streams HELM v3 chart package to out: gzipped tar with chart folder as root
+func (s *Builder) WriteTGZ(out io.Writer) error {
+	if s.Version == "" {
+		return fmt.Errorf("%s/Chart.yaml: version is required for chart package", s.source)
+	}
+	files, err := s.entries(false)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	tw := tar.NewWriter(zw)
+	for _, file := range files {
+		size, err := file.size()
+		if err != nil {
+			return err
+		}
+		if err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     s.Name + "/" + file.Name,
+			Mode:     0644,
+			Size:     size,
+			ModTime:  ModTime,
+		}); err != nil {
+			return err
+		}
+		if err := file.copyTo(tw); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return zw.Close()
+}
@@ -0,0 +1,86 @@
+// Copyright 2017-2021 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package helmchart
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/engine"
+	"helm.sh/helm/v3/pkg/lint"
+	"helm.sh/helm/v3/pkg/lint/support"
+)
+
+// ReleaseName and ReleaseNamespace are used for rendering, like `helm template` does
+const (
+	ReleaseName      = "release-name"
+	ReleaseNamespace = "default"
+)
+
+// Render renders templates of the chart and its subcharts with values merged with overrides
+// by helm engine, like `helm template` does, without a cluster (lookup finds nothing,
+// capabilities are helm defaults).
+// Returns manifests by template location (i.e. templates/deployment.yaml,
+// charts/redis/templates/service.yaml), skipping NOTES.txt and templates with empty output
+func (s *Builder) Render() (map[string]string, error) {
+	chart, err := loader.Load(s.source)
+	if err != nil {
+		return nil, fmt.Errorf("%s load failure %v", s.source, err)
+	}
+	if err := chartutil.ProcessDependencies(chart, s.overrides); err != nil {
+		return nil, fmt.Errorf("dependencies failure %v", err)
+	}
+	values, err := chartutil.ToRenderValues(chart, s.overrides, chartutil.ReleaseOptions{
+		Name:      ReleaseName,
+		Namespace: ReleaseNamespace,
+		Revision:  1,
+		IsInstall: true,
+	}, chartutil.DefaultCapabilities)
+	if err != nil {
+		return nil, fmt.Errorf("values failure %v", err)
+	}
+	rendered, err := engine.Render(chart, values)
+	if err != nil {
+		return nil, fmt.Errorf("templates render failure %s", s.trimError(err))
+	}
+	manifests := map[string]string{}
+	for name, body := range rendered {
+		name = strings.TrimPrefix(name, chart.Name()+"/")
+		if path.Base(name) == "NOTES.txt" || strings.TrimSpace(body) == "" {
+			continue
+		}
+		manifests[name] = body
+	}
+	return manifests, nil
+}
+
+// trimError leaves template location relative to the chart in error message
+func (s *Builder) trimError(err error) string {
+	return strings.Replace(err.Error(), s.Name+"/", "", -1)
+}
+
+// Lint runs `helm lint` checks of the chart with values merged with overrides:
+// Chart.yaml, values (and values.schema.json), rendered templates and dependencies.
+// Errors fail the check, warnings are logged
+func (s *Builder) Lint() error {
+	linter := lint.All(s.source, s.overrides, ReleaseNamespace, false)
+	problems := []string{}
+	for _, m := range linter.Messages {
+		switch m.Severity {
+		case support.ErrorSev:
+			problems = append(problems, fmt.Sprintf("%s: %v", m.Path, m.Err))
+		case support.WarningSev:
+			log.Printf("[WARN] %s: lint %s: %v", s.source, m.Path, m.Err)
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("chart lint failure:\n  - %s", strings.Join(problems, "\n  - "))
+}
@@ -0,0 +1,86 @@
+// Copyright 2017-2021 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package helmchart
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuilder_Render(t *testing.T) {
+	r := require.New(t)
+	source := newTestChart(t, map[string]string{
+		"templates/_helpers.tpl": `{{- define "web.name" -}}{{ .Chart.Name }}-{{ .Release.Name }}{{- end -}}`,
+		"templates/deployment.yaml": "apiVersion: apps/v1\nkind: Deployment\n" +
+			"metadata:\n  name: {{ include \"web.name\" . }}\n" +
+			"spec:\n  replicas: {{ .Values.replicas }}\n  selector:\n    matchLabels:\n      app: web\n",
+		"templates/NOTES.txt":     "installed {{ .Release.Name }}",
+		"templates/disabled.yaml": "{{- if .Values.disabled }}\nkind: Job\n{{- end }}",
+	})
+	chart, err := New(source, map[string]interface{}{"replicas": 3}, nil)
+	r.NoError(err)
+	manifests, err := chart.Render()
+	r.NoError(err)
+	r.Equal(map[string]string{
+		"templates/deployment.yaml": "apiVersion: apps/v1\nkind: Deployment\n" +
+			"metadata:\n  name: web-release-name\nspec:\n  replicas: 3\n  selector:\n    matchLabels:\n      app: web\n",
+	}, manifests)
+	r.NoError(chart.Lint())
+}
+
+func TestBuilder_RenderFailure(t *testing.T) {
+	r := require.New(t)
+	source := newTestChart(t, map[string]string{
+		"templates/service.yaml": "kind: Service\nport: {{ required \"port is required\" .Values.port }}\n",
+	})
+	chart, err := New(source, nil, nil)
+	r.NoError(err)
+	_, err = chart.Render()
+	r.Error(err)
+	r.Contains(err.Error(), "templates/service.yaml:2:")
+	r.Contains(err.Error(), "port is required")
+}
+
+func TestBuilder_RenderSubcharts(t *testing.T) {
+	r := require.New(t)
+	source := newTestChart(t, map[string]string{
+		"values.yaml":                       "redis:\n  port: 6379\n",
+		"templates/service.yaml":            "kind: Service\nname: {{ .Release.Name }}\n",
+		"charts/redis/Chart.yaml":           "apiVersion: v2\nname: redis\nversion: 1.0.0\n",
+		"charts/redis/values.yaml":          "port: 1\n",
+		"charts/redis/templates/redis.yaml": "kind: Service\nport: {{ .Values.port }}\nregistry: {{ .Values.global.registry }}\n",
+	})
+	chart, err := New(source, map[string]interface{}{"global": map[string]interface{}{"registry": "example.com"}}, nil)
+	r.NoError(err)
+	manifests, err := chart.Render()
+	r.NoError(err)
+	r.Equal(map[string]string{
+		"templates/service.yaml":            "kind: Service\nname: release-name\n",
+		"charts/redis/templates/redis.yaml": "kind: Service\nport: 6379\nregistry: example.com\n",
+	}, manifests)
+}
+
+func TestBuilder_Lint(t *testing.T) {
+	r := require.New(t)
+	source := newTestChart(t, map[string]string{
+		"templates/service.yaml": "apiVersion: v1\nkind: Service\nmetadata:\n  name: {{ .Values.name }}\n port: 80\n",
+	})
+	chart, err := New(source, map[string]interface{}{"name": "web"}, nil)
+	r.NoError(err)
+	err = chart.Lint()
+	r.Error(err)
+	r.Contains(err.Error(), "templates/service.yaml: unable to parse YAML")
+
+	source = newTestChart(t, map[string]string{
+		"Chart.yaml":             "apiVersion: v2\nname: web\nversion: latest\n",
+		"templates/service.yaml": "apiVersion: v1\nkind: Service\nmetadata:\n  name: web\n",
+	})
+	chart, err = New(source, nil, nil)
+	r.NoError(err)
+	err = chart.Lint()
+	r.Error(err)
+	r.Contains(err.Error(), "Chart.yaml: version 'latest' is not a valid SemVer")
+}
@@ -0,0 +1,66 @@
+// Copyright 2017-2021 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package helmchart
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// SchemaFile is JSON schema of chart values
+const SchemaFile = "values.schema.json"
+
+// MergeValues returns copy of values with overrides merged over them, like helm does:
+// maps are merged recursively, other values are replaced, null removes the key
+func MergeValues(values, overrides map[string]interface{}) map[string]interface{} {
+	res := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		res[k] = v
+	}
+	for k, v := range overrides {
+		if v == nil {
+			delete(res, k)
+			continue
+		}
+		src, okSrc := v.(map[string]interface{})
+		dst, okDst := res[k].(map[string]interface{})
+		if okSrc && okDst {
+			res[k] = MergeValues(dst, src)
+		} else {
+			res[k] = v
+		}
+	}
+	return res
+}
+
+// ValidateSchema validates values against values.schema.json of the chart, if present
+func ValidateSchema(source string, values map[string]interface{}) error {
+	body, err := ioutil.ReadFile(filepath.Join(source, SchemaFile))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("%s/%s read failure %v", source, SchemaFile, err)
+	}
+	result, err := gojsonschema.Validate(
+		gojsonschema.NewBytesLoader(body),
+		gojsonschema.NewGoLoader(values),
+	)
+	if err != nil {
+		return fmt.Errorf("%s/%s failure %v", source, SchemaFile, err)
+	}
+	if result.Valid() {
+		return nil
+	}
+	problems := make([]string, 0, len(result.Errors()))
+	for _, e := range result.Errors() {
+		problems = append(problems, fmt.Sprintf("%s: %s", e.Field(), e.Description()))
+	}
+	return fmt.Errorf("values don't match %s:\n  - %s", SchemaFile, strings.Join(problems, "\n  - "))
+}
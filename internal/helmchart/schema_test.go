@@ -0,0 +1,39 @@
+// Copyright 2017-2021 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package helmchart
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateSchema(t *testing.T) {
+	r := require.New(t)
+	source := newTestChart(t, map[string]string{
+		"values.yaml": "replicas: 1\nimage:\n  tag: latest\n",
+		"values.schema.json": `{
+			"type": "object",
+			"required": ["image"],
+			"properties": {
+				"replicas": {"type": "integer", "minimum": 1},
+				"image": {"type": "object", "properties": {"tag": {"type": "string"}}}
+			}
+		}`,
+	})
+	values, err := LoadValues(source)
+	r.NoError(err)
+
+	overrides, err := ParseOverrides("replicas: 2\nimage: {tag: v1}", nil)
+	r.NoError(err)
+	r.NoError(ValidateSchema(source, MergeValues(values, overrides)))
+
+	overrides, err = ParseOverrides("replicas: 0\nimage: {tag: 1}", nil)
+	r.NoError(err)
+	err = ValidateSchema(source, MergeValues(values, overrides))
+	r.Error(err)
+	r.Contains(err.Error(), "replicas: Must be greater than or equal to 1")
+	r.Contains(err.Error(), "image.tag: Invalid type. Expected: string, given: integer")
+}
@@ -0,0 +1,156 @@
+// Copyright 2017-2021 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package helmchart
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// LoadValues reads and parses values.yaml of the chart
+func LoadValues(source string) (map[string]interface{}, error) {
+	body, err := ioutil.ReadFile(filepath.Join(source, "values.yaml"))
+	if err != nil {
+		return nil, fmt.Errorf("%s/values.yaml read failure %v", source, err)
+	}
+	var t interface{}
+	if err := yaml.Unmarshal(body, &t); err != nil {
+		return nil, fmt.Errorf("%s/values.yaml parse failure %v", source, err)
+	}
+	values, ok := normalize(t).(map[string]interface{})
+	if !ok && t != nil {
+		return nil, fmt.Errorf("%s/values.yaml must be a map, found %T", source, t)
+	}
+	if values == nil {
+		values = map[string]interface{}{}
+	}
+	return values, nil
+}
+
+// GlobalKey is the key of values shared by the chart with its subcharts
+const GlobalKey = "global"
+
+// Dependencies returns names of the subcharts, which values could be overridden
+// by the chart: dependencies (or their aliases) of Chart.yaml and requirements.yaml
+// and charts in charts/ folder, sorted
+func Dependencies(source string) ([]string, error) {
+	names := map[string]interface{}{}
+	for _, file := range []string{"Chart.yaml", "requirements.yaml"} {
+		body, err := ioutil.ReadFile(filepath.Join(source, file))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("%s/%s read failure %v", source, file, err)
+		}
+		var decl struct {
+			Dependencies []struct {
+				Name  string `yaml:"name"`
+				Alias string `yaml:"alias"`
+			} `yaml:"dependencies"`
+		}
+		if err := yaml.Unmarshal(body, &decl); err != nil {
+			return nil, fmt.Errorf("%s/%s parse failure %v", source, file, err)
+		}
+		for _, dep := range decl.Dependencies {
+			if dep.Alias != "" {
+				names[dep.Alias] = true
+			} else if dep.Name != "" {
+				names[dep.Name] = true
+			}
+		}
+	}
+	infos, err := ioutil.ReadDir(filepath.Join(source, "charts"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("%s/charts read failure %v", source, err)
+	}
+	for _, info := range infos {
+		name := info.Name()
+		if !info.IsDir() {
+			if !strings.HasSuffix(name, ".tgz") {
+				continue
+			}
+			// packaged subchart is named <name>-<version>.tgz
+			name = strings.TrimSuffix(name, ".tgz")
+			if i := strings.LastIndex(name, "-"); i > 0 && i+1 < len(name) && name[i+1] >= '0' && name[i+1] <= '9' {
+				name = name[:i]
+			}
+		}
+		names[name] = true
+	}
+	return sortedKeys(names), nil
+}
+
+// CheckOverrides verifies that every override exists in values.yaml
+// and is permitted by allowed list, reporting all problems at once.
+// Global values and values of the dependencies are not checked,
+// as subcharts define them
+func CheckOverrides(values, overrides map[string]interface{}, dependencies, allowed []string) error {
+	free := map[string]bool{GlobalKey: true}
+	for _, name := range dependencies {
+		free[name] = true
+	}
+	var problems []string
+	for _, path := range Paths(overrides) {
+		if keys := SplitPath(path); !free[keys[0]] {
+			if err := resolvePath(values, keys); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", path, err))
+			}
+		}
+		if len(allowed) > 0 && !isAllowed(path, allowed) {
+			problems = append(problems, fmt.Sprintf("%s: not in allowed list", path))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid overrides:\n  - %s", strings.Join(problems, "\n  - "))
+	}
+	return nil
+}
+
+// resolvePath checks that the path exists in values.
+// Keys under empty (or null) maps are free-form and accepted
+func resolvePath(values map[string]interface{}, path []string) error {
+	var cur interface{} = values
+	for i, key := range path {
+		switch m := cur.(type) {
+		case map[string]interface{}:
+			next, ok := m[key]
+			if !ok {
+				if len(m) == 0 {
+					return nil
+				}
+				return fmt.Errorf("not found in values.yaml (%s has keys: %s)",
+					parentName(path[:i]), strings.Join(sortedKeys(m), ", "))
+			}
+			cur = next
+		case nil:
+			return nil
+		default:
+			return fmt.Errorf("%s is %T in values.yaml, not a map", JoinPath(path[:i]), cur)
+		}
+	}
+	return nil
+}
+
+func parentName(path []string) string {
+	if len(path) == 0 {
+		return "root"
+	}
+	return JoinPath(path)
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,72 @@
+// Copyright 2017-2021 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package helmchart
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckOverrides(t *testing.T) {
+	r := require.New(t)
+	values, err := LoadValues(newTestChart(t, map[string]string{
+		"values.yaml": "replicas: 1\nimage:\n  repository: web\n  tag: latest\npodAnnotations: {}\n",
+	}))
+	r.NoError(err)
+
+	overrides, err := ParseOverrides("podAnnotations: {team: web}", map[string]interface{}{
+		"image.tag": "v1",
+	})
+	r.NoError(err)
+	r.NoError(CheckOverrides(values, overrides, nil, []string{"image", "podAnnotations"}))
+
+	overrides, err = ParseOverrides("", map[string]interface{}{
+		"image.tga": "v1",
+		"replicas":  "2",
+		"image.tag": "v1",
+	})
+	r.NoError(err)
+	r.EqualError(CheckOverrides(values, overrides, nil, []string{"image"}), `invalid overrides:
+  - image.tga: not found in values.yaml (image has keys: repository, tag)
+  - replicas: not in allowed list`)
+}
+
+func TestCheckOverrides_Umbrella(t *testing.T) {
+	r := require.New(t)
+	source := newTestChart(t, map[string]string{
+		"Chart.yaml": "apiVersion: v2\nname: web\nversion: 0.1.0\n" +
+			"dependencies:\n- name: postgresql\n  alias: db\n- name: redis\n",
+		"values.yaml":               "replicas: 1\nredis:\n  enabled: true\n",
+		"charts/redis-10.5.7.tgz":   "tgz",
+		"charts/metrics/Chart.yaml": "apiVersion: v2\nname: metrics\nversion: 1.0.0\n",
+		"charts/README.md":          "vendored charts",
+	})
+	values, err := LoadValues(source)
+	r.NoError(err)
+	dependencies, err := Dependencies(source)
+	r.NoError(err)
+	r.Equal([]string{"db", "metrics", "redis"}, dependencies)
+
+	overrides, err := ParseOverrides("", map[string]interface{}{
+		"global.imageRegistry":   "registry.example.com",
+		"redis.password":         "s3cr3t",
+		"db.auth.database":       "web",
+		"metrics.serviceMonitor": "true",
+		"replicas":               "2",
+	})
+	r.NoError(err)
+	r.NoError(CheckOverrides(values, overrides, dependencies, nil))
+	r.EqualError(CheckOverrides(values, overrides, dependencies, []string{"replicas", "global"}), `invalid overrides:
+  - db.auth.database: not in allowed list
+  - metrics.serviceMonitor: not in allowed list
+  - redis.password: not in allowed list`)
+
+	// without dependencies subchart keys are unknown
+	overrides, err = ParseOverrides("", map[string]interface{}{"postgresql.auth.database": "web"})
+	r.NoError(err)
+	r.EqualError(CheckOverrides(values, overrides, dependencies, nil), `invalid overrides:
+  - postgresql.auth.database: not found in values.yaml (root has keys: redis, replicas)`)
+}
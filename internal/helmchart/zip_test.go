@@ -0,0 +1,260 @@
+// Copyright 2017-2021 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package helmchart
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newTestChart creates chart folder with the given files
+func newTestChart(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "helmchart")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	base := map[string]string{
+		"Chart.yaml":  "apiVersion: v2\nname: web\nversion: 0.1.0\n",
+		"values.yaml": "replicas: 1\n",
+	}
+	for name, body := range files {
+		base[name] = body
+	}
+	for name, body := range base {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, []byte(body), 0644))
+	}
+	return dir
+}
+
+// zipEntries returns sorted names of the archive entries
+func zipEntries(t *testing.T, chart *Builder) []string {
+	buf := new(bytes.Buffer)
+	require.NoError(t, chart.WriteZIP(buf))
+	z, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	names := []string{}
+	for _, f := range z.File {
+		names = append(names, f.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestBuilder_ZIPNestedTemplates(t *testing.T) {
+	r := require.New(t)
+	source := newTestChart(t, map[string]string{
+		"templates/_helpers.tpl":          "",
+		"templates/ingress/web.yaml":      "kind: Ingress",
+		"templates/worker/web.yaml":       "kind: Deployment",
+		"templates/tests/connection.yaml": "kind: Pod",
+	})
+	chart, err := New(source, nil, nil)
+	r.NoError(err)
+	r.Equal([]string{
+		"Chart.yaml",
+		"allowed.txt",
+		"override.yaml",
+		"templates/_helpers.tpl",
+		"templates/ingress/web.yaml",
+		"templates/tests/connection.yaml",
+		"templates/worker/web.yaml",
+		"values.yaml",
+	}, zipEntries(t, chart))
+}
+
+func TestBuilder_ZIPUmbrellaChart(t *testing.T) {
+	r := require.New(t)
+	source := newTestChart(t, map[string]string{
+		"Chart.lock":                "dependencies: []",
+		"README.md":                 "# web",
+		"values.schema.json":        "{}",
+		"charts/redis-1.0.0.tgz":    "tgz",
+		"charts/db/Chart.yaml":      "name: db",
+		"crds/crontab.yaml":         "kind: CustomResourceDefinition",
+		"templates/NOTES.txt":       "notes",
+		"templates/deployment.yaml": "kind: Deployment",
+	})
+	chart, err := New(source, nil, nil)
+	r.NoError(err)
+	r.Equal([]string{
+		"Chart.lock",
+		"Chart.yaml",
+		"README.md",
+		"allowed.txt",
+		"charts/db/Chart.yaml",
+		"charts/redis-1.0.0.tgz",
+		"crds/crontab.yaml",
+		"override.yaml",
+		"templates/NOTES.txt",
+		"templates/deployment.yaml",
+		"values.schema.json",
+		"values.yaml",
+	}, zipEntries(t, chart))
+}
+
+func TestBuilder_HelmIgnore(t *testing.T) {
+	r := require.New(t)
+	files := map[string]string{
+		".helmignore":                 "# editor files\n*.swp\ntemplates/drafts/\n*.md\n!README.md\n",
+		"README.md":                   "# web",
+		"templates/deployment.yaml":   "kind: Deployment",
+		"templates/.deployment.yaml~": "backup",
+	}
+	source := newTestChart(t, files)
+	chart, err := New(source, nil, nil)
+	r.NoError(err)
+
+	files["templates/.deployment.yaml.swp"] = "swap"
+	files["templates/drafts/job.yaml"] = "kind: Job"
+	changed, err := New(newTestChart(t, files), nil, nil)
+	r.NoError(err)
+	r.Equal(chart.Hash, changed.Hash)
+
+	r.Equal([]string{
+		".helmignore",
+		"Chart.yaml",
+		"README.md",
+		"allowed.txt",
+		"override.yaml",
+		"templates/deployment.yaml",
+		"values.yaml",
+	}, zipEntries(t, changed))
+}
+
+func TestBuilder_ZIPAnyChartFile(t *testing.T) {
+	r := require.New(t)
+	files := map[string]string{
+		"files/app.conf":            "listen 80",
+		"notes.txt":                 "chart notes",
+		"override.yaml":             "replicas: 5",
+		"templates/configmap.yaml":  "{{ .Files.Get \"files/app.conf\" }}",
+		"templates/deployment.yaml": "kind: Deployment",
+	}
+	chart, err := New(newTestChart(t, files), nil, nil)
+	r.NoError(err)
+	r.Equal([]string{
+		"Chart.yaml",
+		"allowed.txt",
+		"files/app.conf",
+		"notes.txt",
+		"override.yaml",
+		"templates/configmap.yaml",
+		"templates/deployment.yaml",
+		"values.yaml",
+	}, zipEntries(t, chart))
+
+	// any shipped file changes the chart, override.yaml is generated
+	files["files/app.conf"] = "listen 8080"
+	changed, err := New(newTestChart(t, files), nil, nil)
+	r.NoError(err)
+	r.NotEqual(chart.Hash, changed.Hash)
+	delete(files, "override.yaml")
+	same, err := New(newTestChart(t, files), nil, nil)
+	r.NoError(err)
+	r.Equal(changed.Hash, same.Hash)
+}
+
+func TestBuilder_HashCoversGeneratedFiles(t *testing.T) {
+	r := require.New(t)
+	source := newTestChart(t, map[string]string{
+		"templates/deployment.yaml": "kind: Deployment",
+	})
+	chart, err := New(source, nil, nil)
+	r.NoError(err)
+	same, err := New(source, nil, nil)
+	r.NoError(err)
+	r.Equal(chart.Hash, same.Hash)
+
+	overridden, err := New(source, map[string]interface{}{"replicas": 2}, nil)
+	r.NoError(err)
+	r.NotEqual(chart.Hash, overridden.Hash)
+	r.NotEqual(chart.GetZipName(), overridden.GetZipName())
+
+	allowed, err := New(source, nil, []string{"replicas"})
+	r.NoError(err)
+	r.NotEqual(chart.Hash, allowed.Hash)
+	r.NotEqual(overridden.Hash, allowed.Hash)
+}
+
+func TestBuilder_Reproducible(t *testing.T) {
+	r := require.New(t)
+	source := newTestChart(t, map[string]string{
+		"README.md":                 "# web",
+		"templates/deployment.yaml": "kind: Deployment",
+	})
+	overrides := map[string]interface{}{"image": map[string]interface{}{"tag": "1.0", "name": "web"}, "replicas": 2}
+	for _, format := range []string{FormatZIP, FormatTGZ} {
+		first, err := New(source, overrides, []string{"replicas"})
+		r.NoError(err)
+		expected := new(bytes.Buffer)
+		_, err = first.WriteArchive(expected, format)
+		r.NoError(err)
+
+		// file times must not affect the archive
+		later := time.Now().Add(time.Hour)
+		r.NoError(os.Chtimes(filepath.Join(source, "README.md"), later, later))
+		second, err := New(source, overrides, []string{"replicas"})
+		r.NoError(err)
+		actual := new(bytes.Buffer)
+		_, err = second.WriteArchive(actual, format)
+		r.NoError(err)
+		r.Equal(expected.Bytes(), actual.Bytes(), format)
+	}
+
+	chart, err := New(source, nil, nil)
+	r.NoError(err)
+	buf := new(bytes.Buffer)
+	r.NoError(chart.WriteZIP(buf))
+	z, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	r.NoError(err)
+	names := []string{}
+	for _, f := range z.File {
+		r.True(ModTime.Equal(f.Modified), f.Name)
+		names = append(names, f.Name)
+	}
+	r.True(sort.StringsAreSorted(names))
+}
+
+func TestBuilder_TGZ(t *testing.T) {
+	r := require.New(t)
+	source := newTestChart(t, map[string]string{
+		"templates/deployment.yaml": "kind: Deployment",
+	})
+	chart, err := New(source, nil, nil)
+	r.NoError(err)
+	r.Equal("helm/"+chart.ID+"/web-0.1.0.tgz", chart.GetArchiveName(FormatTGZ))
+
+	buf := new(bytes.Buffer)
+	contentType, err := chart.WriteArchive(buf, FormatTGZ)
+	r.NoError(err)
+	r.Equal("application/gzip", contentType)
+	zr, err := gzip.NewReader(buf)
+	r.NoError(err)
+	tr := tar.NewReader(zr)
+	names := []string{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		r.NoError(err)
+		names = append(names, hdr.Name)
+	}
+	sort.Strings(names)
+	r.Equal([]string{"web/Chart.yaml", "web/templates/deployment.yaml", "web/values.yaml"}, names)
+}
@@ -0,0 +1,139 @@
+// Copyright 2017-2021 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+// Package helmrepo maintains index.yaml of HELM chart repository
+package helmrepo
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/AtlantPlatform/terraform-provider-cicd/internal/storage"
+	"gopkg.in/yaml.v2"
+)
+
+// MaxAttempts is a number of attempts to update index modified concurrently
+const MaxAttempts = 10
+
+// ChartVersion is index entry of chart package
+type ChartVersion struct {
+	APIVersion  string    `yaml:"apiVersion"`
+	Name        string    `yaml:"name"`
+	Version     string    `yaml:"version"`
+	AppVersion  string    `yaml:"appVersion,omitempty"`
+	Description string    `yaml:"description,omitempty"`
+	URLs        []string  `yaml:"urls"`
+	Digest      string    `yaml:"digest"`
+	Created     time.Time `yaml:"created"`
+}
+
+// Index is index.yaml of HELM chart repository
+type Index struct {
+	APIVersion string                     `yaml:"apiVersion"`
+	Entries    map[string][]*ChartVersion `yaml:"entries"`
+	Generated  time.Time                  `yaml:"generated"`
+}
+
+// NewIndex creates empty index
+func NewIndex() *Index {
+	return &Index{APIVersion: "v1", Entries: map[string][]*ChartVersion{}}
+}
+
+// Load parses index.yaml
+func Load(data []byte) (*Index, error) {
+	index := NewIndex()
+	if err := yaml.Unmarshal(data, index); err != nil {
+		return nil, fmt.Errorf("index.yaml parse failure %v", err)
+	}
+	if index.Entries == nil {
+		index.Entries = map[string][]*ChartVersion{}
+	}
+	return index, nil
+}
+
+// Add puts chart version to the index, replacing the same version of the chart
+func (i *Index) Add(v *ChartVersion) {
+	entries := []*ChartVersion{v}
+	for _, e := range i.Entries[v.Name] {
+		if e.Version != v.Version {
+			entries = append(entries, e)
+		}
+	}
+	sort.SliceStable(entries, func(a, b int) bool {
+		return entries[a].Created.After(entries[b].Created)
+	})
+	i.Entries[v.Name] = entries
+}
+
+// Remove deletes chart version located at url from the index
+func (i *Index) Remove(name, url string) {
+	entries := []*ChartVersion{}
+	for _, e := range i.Entries[name] {
+		if len(e.URLs) == 0 || e.URLs[0] != url {
+			entries = append(entries, e)
+		}
+	}
+	if len(entries) == 0 {
+		delete(i.Entries, name)
+	} else {
+		i.Entries[name] = entries
+	}
+}
+
+// Marshal returns index.yaml content
+func (i *Index) Marshal() ([]byte, error) {
+	return yaml.Marshal(i)
+}
+
+// Update applies change to index.yaml stored under the key.
+// Conditional writes are used, so concurrent updates are re-applied, not lost
+func Update(ctx context.Context, store storage.Storage, key string, change func(*Index)) error {
+	for attempt := 1; attempt <= MaxAttempts; attempt++ {
+		index, etag, err := read(ctx, store, key)
+		if err != nil {
+			return err
+		}
+		change(index)
+		index.Generated = time.Now().UTC()
+		body, err := index.Marshal()
+		if err != nil {
+			return err
+		}
+		err = store.Put(ctx, &storage.Object{
+			Key:         key,
+			ContentType: "application/x-yaml",
+			ETag:        etag,
+			Conditional: true,
+		}, bytes.NewReader(body))
+		if err != storage.ErrPreconditionFailed {
+			return err
+		}
+		log.Printf("[WARN] %s was modified concurrently, attempt %d/%d", key, attempt, MaxAttempts)
+	}
+	return fmt.Errorf("%s update failure: too many concurrent modifications", key)
+}
+
+func read(ctx context.Context, store storage.Storage, key string) (*Index, string, error) {
+	body, obj, err := store.Get(ctx, key)
+	if err == storage.ErrNotFound {
+		return NewIndex(), "", nil
+	} else if err != nil {
+		return nil, "", err
+	}
+	defer body.Close()
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, "", err
+	}
+	index, err := Load(data)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %v", key, err)
+	}
+	return index, obj.ETag, nil
+}
@@ -0,0 +1,61 @@
+// Copyright 2017-2021 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package helmrepo
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AtlantPlatform/terraform-provider-cicd/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdate_Concurrent(t *testing.T) {
+	r := require.New(t)
+	dir, err := ioutil.TempDir("", "helmrepo")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+	store := storage.NewLocal(dir)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 5)
+	for n := 0; n < 5; n++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			errs <- Update(ctx, store, "helm/index.yaml", func(index *Index) {
+				index.Add(&ChartVersion{
+					Name:    "web",
+					Version: fmt.Sprintf("0.%d.0", n),
+					URLs:    []string{fmt.Sprintf("%d/web-0.%d.0.tgz", n, n)},
+					Created: time.Now(),
+				})
+			})
+		}(n)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		r.NoError(err)
+	}
+
+	r.NoError(Update(ctx, store, "helm/index.yaml", func(index *Index) {
+		index.Remove("web", "0/web-0.0.0.tgz")
+	}))
+	body, _, err := store.Get(ctx, "helm/index.yaml")
+	r.NoError(err)
+	data, err := ioutil.ReadAll(body)
+	body.Close()
+	r.NoError(err)
+	index, err := Load(data)
+	r.NoError(err)
+	r.Len(index.Entries["web"], 4)
+}
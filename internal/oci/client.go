@@ -0,0 +1,331 @@
+// Copyright 2017-2021 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+// Package oci pushes artifacts to OCI registries using distribution protocol
+package oci
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ManifestMediaType is media type of OCI image manifest
+const ManifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+
+// Descriptor references content in the registry
+type Descriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+}
+
+// Manifest is OCI image manifest
+type Manifest struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType,omitempty"`
+	Config        Descriptor   `json:"config"`
+	Layers        []Descriptor `json:"layers"`
+}
+
+// Blob is content to be pushed to the registry.
+// Content is streamed on upload, so large layers could be read from files
+type Blob struct {
+	MediaType string
+	Content   io.ReadSeeker
+	Size      int64
+	// Digest is sha256 digest of the content (sha256:<hex>)
+	Digest string
+}
+
+// NewBlob creates blob of the content in memory
+func NewBlob(mediaType string, data []byte) *Blob {
+	return &Blob{
+		MediaType: mediaType,
+		Content:   bytes.NewReader(data),
+		Size:      int64(len(data)),
+		Digest:    Digest(data),
+	}
+}
+
+func (b *Blob) descriptor() Descriptor {
+	return Descriptor{
+		MediaType: b.MediaType,
+		Digest:    b.Digest,
+		Size:      b.Size,
+	}
+}
+
+// Digest returns sha256 digest of the content
+func Digest(data []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+}
+
+// Client of OCI registry
+type Client struct {
+	// Username and Password are used for basic auth or to obtain bearer token
+	Username string
+	Password string
+	// PlainHTTP disables TLS (for local registries)
+	PlainHTTP bool
+
+	http  *http.Client
+	token string
+}
+
+// New creates registry client.
+// http.DefaultClient is used if httpClient is nil
+func New(httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{http: httpClient}
+}
+
+// ParseReference splits oci://host/repository into registry host and repository
+func ParseReference(ref string) (string, string, error) {
+	ref = strings.TrimPrefix(ref, "oci://")
+	parts := strings.SplitN(ref, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid OCI reference %s, host/repository expected", ref)
+	}
+	return parts[0], strings.TrimSuffix(parts[1], "/"), nil
+}
+
+// Push uploads config and layers and tags manifest referencing them.
+// Returns digest of the manifest
+func (c *Client) Push(ctx context.Context, host, repository, tag string, config *Blob, layers ...*Blob) (string, error) {
+	manifest := Manifest{
+		SchemaVersion: 2,
+		MediaType:     ManifestMediaType,
+		Config:        config.descriptor(),
+		Layers:        []Descriptor{},
+	}
+	for _, blob := range append([]*Blob{config}, layers...) {
+		if err := c.pushBlob(ctx, host, repository, blob); err != nil {
+			return "", err
+		}
+	}
+	for _, layer := range layers {
+		manifest.Layers = append(manifest.Layers, layer.descriptor())
+	}
+	body, err := json.Marshal(&manifest)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.do(ctx, http.MethodPut, c.url(host, "/v2/"+repository+"/manifests/"+tag), bytes.NewReader(body), ManifestMediaType, repository)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	digest := Digest(body)
+	if remote := resp.Header.Get("Docker-Content-Digest"); remote != "" && remote != digest {
+		return "", fmt.Errorf("manifest digest mismatch, found %s, expected %s", remote, digest)
+	}
+	return digest, nil
+}
+
+func (c *Client) pushBlob(ctx context.Context, host, repository string, blob *Blob) error {
+	digest := blob.Digest
+	blobURL := c.url(host, "/v2/"+repository+"/blobs/"+digest)
+	if resp, err := c.do(ctx, http.MethodHead, blobURL, nil, "", repository); err == nil {
+		resp.Body.Close()
+		return nil
+	} else if !IsNotFound(err) {
+		return err
+	}
+
+	resp, err := c.do(ctx, http.MethodPost, c.url(host, "/v2/"+repository+"/blobs/uploads/"), nil, "", repository)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	location, err := resp.Location()
+	if err != nil {
+		return fmt.Errorf("blob upload location: %v", err)
+	}
+	query := location.Query()
+	query.Set("digest", digest)
+	location.RawQuery = query.Encode()
+
+	resp, err = c.do(ctx, http.MethodPut, location.String(), blob.Content, "application/octet-stream", repository)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (c *Client) url(host, path string) string {
+	scheme := "https"
+	if c.PlainHTTP {
+		scheme = "http"
+	}
+	return scheme + "://" + host + path
+}
+
+// Error is returned on unexpected registry response
+type Error struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Body       string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s %s responded with status %d (%s)", e.Method, e.URL, e.StatusCode, e.Body)
+}
+
+// IsNotFound reports whether registry responded with 404
+func IsNotFound(err error) bool {
+	e, ok := err.(*Error)
+	return ok && e.StatusCode == http.StatusNotFound
+}
+
+func (c *Client) do(ctx context.Context, method, target string, body io.ReadSeeker, contentType, repository string) (*http.Response, error) {
+	resp, err := c.send(ctx, method, target, body, contentType)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+		if err := c.authorize(ctx, challenge, repository); err != nil {
+			return nil, err
+		}
+		if resp, err = c.send(ctx, method, target, body, contentType); err != nil {
+			return nil, err
+		}
+	}
+	if resp.StatusCode >= 300 {
+		buf, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, &Error{Method: method, URL: target, StatusCode: resp.StatusCode, Body: string(buf)}
+	}
+	return resp, nil
+}
+
+// send makes the request, body (if any) is sent from its start, so it could be resent
+func (c *Client) send(ctx context.Context, method, target string, body io.ReadSeeker, contentType string) (*http.Response, error) {
+	req, err := http.NewRequest(method, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if body != nil {
+		n, err := body.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := body.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		req.Body = ioutil.NopCloser(body)
+		req.ContentLength = n
+		if n == 0 {
+			req.Body = http.NoBody
+		}
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if method == http.MethodHead || method == http.MethodGet {
+		req.Header.Set("Accept", ManifestMediaType)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	} else if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+	return c.http.Do(req)
+}
+
+// authorize obtains bearer token following the challenge of the registry
+func (c *Client) authorize(ctx context.Context, challenge, repository string) error {
+	if !strings.HasPrefix(challenge, "Bearer ") {
+		return fmt.Errorf("registry authorization failed (%s)", challenge)
+	}
+	params := parseChallenge(strings.TrimPrefix(challenge, "Bearer "))
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return fmt.Errorf("invalid registry auth realm %q", params["realm"])
+	}
+	query := realm.Query()
+	if params["service"] != "" {
+		query.Set("service", params["service"])
+	}
+	query.Set("scope", "repository:"+repository+":pull,push")
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	buf, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		return &Error{Method: http.MethodGet, URL: realm.String(), StatusCode: resp.StatusCode, Body: string(buf)}
+	}
+	var out struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal(buf, &out); err != nil {
+		return fmt.Errorf("registry token decode failure %v", err)
+	}
+	c.token = out.Token
+	if c.token == "" {
+		c.token = out.AccessToken
+	}
+	if c.token == "" {
+		return fmt.Errorf("registry token is empty")
+	}
+	return nil
+}
+
+// parseChallenge parses key="value" pairs of WWW-Authenticate header
+func parseChallenge(s string) map[string]string {
+	res := map[string]string{}
+	for s != "" {
+		eq := strings.Index(s, "=")
+		if eq < 0 {
+			break
+		}
+		key := strings.TrimSpace(s[:eq])
+		s = s[eq+1:]
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			end := strings.Index(s[1:], `"`)
+			if end < 0 {
+				value, s = s[1:], ""
+			} else {
+				value, s = s[1:end+1], s[end+2:]
+			}
+		} else if comma := strings.Index(s, ","); comma >= 0 {
+			value, s = s[:comma], s[comma:]
+		} else {
+			value, s = s, ""
+		}
+		res[key] = value
+		s = strings.TrimLeft(s, ", ")
+	}
+	return res
+}
@@ -0,0 +1,105 @@
+// Copyright 2017-2021 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package oci
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// registry is in-process stand-in of distribution registry with token auth
+type registry struct {
+	sync.Mutex
+	url       string
+	blobs     map[string][]byte
+	manifests map[string][]byte
+}
+
+func (reg *registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	reg.Lock()
+	defer reg.Unlock()
+	if req.URL.Path == "/token" {
+		if user, pass, _ := req.BasicAuth(); user != "user" || pass != "pass" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"token":"t0ken"}`))
+		return
+	}
+	if req.Header.Get("Authorization") != "Bearer t0ken" {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="`+reg.url+`/token",service="registry"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	body, _ := ioutil.ReadAll(req.Body)
+	switch {
+	case req.Method == http.MethodHead && strings.Contains(req.URL.Path, "/blobs/"):
+		if _, ok := reg.blobs[req.URL.Path[strings.LastIndex(req.URL.Path, "/")+1:]]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	case req.Method == http.MethodPost && strings.HasSuffix(req.URL.Path, "/blobs/uploads/"):
+		w.Header().Set("Location", "/v2/upload/1?state=x")
+		w.WriteHeader(http.StatusAccepted)
+	case req.Method == http.MethodPut && strings.HasPrefix(req.URL.Path, "/v2/upload/"):
+		digest := req.URL.Query().Get("digest")
+		// monolithic upload must have length, not chunked encoding
+		if Digest(body) != digest || req.ContentLength != int64(len(body)) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		reg.blobs[digest] = body
+		w.WriteHeader(http.StatusCreated)
+	case req.Method == http.MethodPut && strings.Contains(req.URL.Path, "/manifests/"):
+		reg.manifests[req.URL.Path] = body
+		w.Header().Set("Docker-Content-Digest", Digest(body))
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func TestClient_Push(t *testing.T) {
+	r := require.New(t)
+	reg := &registry{blobs: map[string][]byte{}, manifests: map[string][]byte{}}
+	srv := httptest.NewServer(reg)
+	defer srv.Close()
+	reg.url = srv.URL
+
+	host, repository, err := ParseReference("oci://" + strings.TrimPrefix(srv.URL, "http://") + "/charts/web")
+	r.NoError(err)
+	r.Equal("charts/web", repository)
+
+	cli := New(nil)
+	cli.PlainHTTP = true
+	cli.Username, cli.Password = "user", "pass"
+	config := NewBlob("application/vnd.cncf.helm.config.v1+json", []byte(`{"name":"web"}`))
+	// layer is streamed from the file
+	f, err := ioutil.TempFile("", "layer")
+	r.NoError(err)
+	defer os.Remove(f.Name())
+	defer f.Close()
+	_, err = f.Write([]byte("tgz"))
+	r.NoError(err)
+	layer := &Blob{
+		MediaType: "application/vnd.cncf.helm.chart.content.v1.tar+gzip",
+		Content:   f,
+		Size:      3,
+		Digest:    Digest([]byte("tgz")),
+	}
+	digest, err := cli.Push(context.Background(), host, repository, "0.1.0", config, layer)
+	r.NoError(err)
+	r.Len(reg.blobs, 2)
+	r.Equal("tgz", string(reg.blobs[layer.Digest]))
+
+	r.Equal(digest, Digest(reg.manifests["/v2/charts/web/manifests/0.1.0"]))
+}
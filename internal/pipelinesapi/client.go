@@ -0,0 +1,199 @@
+// Copyright 2017-2021 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+// Package pipelinesapi is a client of the pipelines server API
+package pipelinesapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// SecretHeader carries pipeline secret for requests without body
+const SecretHeader = "X-Pipeline-Secret"
+
+// Error is returned when pipelines API responds with non-successful status
+type Error struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Body       string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s %s responded with status %d (%s)",
+		e.Method, e.Path, e.StatusCode, e.Body)
+}
+
+// IsNotFound reports whether the error means that
+// pipeline doesn't exist (or is no longer active) on the server
+func IsNotFound(err error) bool {
+	if e, ok := err.(*Error); ok {
+		return e.StatusCode == http.StatusNotFound || e.StatusCode == http.StatusGone
+	}
+	return false
+}
+
+// Credentials authenticate requests to the pipelines API.
+// Bearer token takes precedence over basic auth
+type Credentials struct {
+	Token    string
+	Username string
+	Password string
+}
+
+func (cr Credentials) apply(req *http.Request) {
+	if cr.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+cr.Token)
+	} else if cr.Username != "" {
+		req.SetBasicAuth(cr.Username, cr.Password)
+	}
+}
+
+// Client of the pipelines server
+type Client struct {
+	// Credentials are attached to every request
+	Credentials Credentials
+	// MaxRetries is a number of retries of failed requests
+	MaxRetries int
+
+	root       string
+	http       *http.Client
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+// New creates pipelines API client for the given API root.
+// http.DefaultClient is used if httpClient is nil
+func New(root string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{
+		root:       strings.TrimSuffix(root, "/"),
+		http:       httpClient,
+		minBackoff: time.Second,
+		maxBackoff: 30 * time.Second,
+	}
+}
+
+// Activate creates or updates the pipeline.
+// Server must echo the ID of the pipeline back
+func (c *Client) Activate(ctx context.Context, p *Pipeline) (*ActivateResponse, error) {
+	var out ActivateResponse
+	if err := c.do(ctx, http.MethodPost, "/api/pipelines/activate", "", p, &out); err != nil {
+		return nil, err
+	}
+	if p.ID != out.ID {
+		return nil, fmt.Errorf("IDs don't match, found %s, expected %s", out.ID, p.ID)
+	}
+	return &out, nil
+}
+
+// Deactivate removes the pipeline
+func (c *Client) Deactivate(ctx context.Context, ref Ref) error {
+	return c.do(ctx, http.MethodPost, "/api/pipelines/deactivate", "", &ref, nil)
+}
+
+// Get returns the pipeline by its reference.
+// Use IsNotFound to check whether pipeline is gone
+func (c *Client) Get(ctx context.Context, ref Ref) (*Pipeline, error) {
+	var out Pipeline
+	path := "/api/pipelines/" + url.PathEscape(ref.ID)
+	if err := c.do(ctx, http.MethodGet, path, ref.Secret, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// List returns all pipelines registered on the server
+func (c *Client) List(ctx context.Context) ([]Pipeline, error) {
+	var out []Pipeline
+	if err := c.do(ctx, http.MethodGet, "/api/pipelines", "", nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *Client) do(ctx context.Context, method, path, secret string, in, out interface{}) error {
+	var payload []byte
+	if in != nil {
+		buf, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		log.Printf("[DEBUG] pipelinesapi: %s %s %s", method, path, string(buf))
+		payload = buf
+	}
+
+	var buf []byte
+	for attempt := 0; ; attempt++ {
+		resp, err := c.send(ctx, method, path, secret, payload)
+		if err == nil {
+			buf, err = ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+		}
+		if !c.shouldRetry(ctx, method, resp, err) || attempt >= c.MaxRetries {
+			if err != nil {
+				return fmt.Errorf("%s %s error: %v", method, path, err)
+			}
+			if attempt > 0 {
+				log.Printf("[DEBUG] pipelinesapi: %s %s done after %d retries", method, path, attempt)
+			}
+			if resp.StatusCode >= 300 {
+				return &Error{
+					Method:     method,
+					Path:       path,
+					StatusCode: resp.StatusCode,
+					Body:       string(buf),
+				}
+			}
+			break
+		}
+		wait := c.backoff(attempt, resp)
+		log.Printf("[WARN] pipelinesapi: %s %s attempt %d/%d failed (%s), retrying in %v",
+			method, path, attempt+1, c.MaxRetries+1, describe(resp, err), wait)
+		if err := sleep(ctx, wait); err != nil {
+			return fmt.Errorf("%s %s error: %v", method, path, err)
+		}
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(buf, out); err != nil {
+		return fmt.Errorf("%s %s decode failure %v (%s)", method, path, err, string(buf))
+	}
+	return nil
+}
+
+func (c *Client) send(ctx context.Context, method, path, secret string, payload []byte) (*http.Response, error) {
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequest(method, c.root+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if secret != "" {
+		req.Header.Set(SecretHeader, secret)
+	}
+	c.Credentials.apply(req)
+	return c.http.Do(req)
+}
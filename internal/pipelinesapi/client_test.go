@@ -0,0 +1,143 @@
+// Copyright 2017-2021 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package pipelinesapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_Activate(t *testing.T) {
+	r := require.New(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.Equal(http.MethodPost, req.Method)
+		r.Equal("/api/pipelines/activate", req.URL.Path)
+		var in Pipeline
+		r.NoError(json.NewDecoder(req.Body).Decode(&in))
+		json.NewEncoder(w).Encode(&ActivateResponse{ID: in.ID, Secret: "s3cr3t"})
+	}))
+	defer srv.Close()
+
+	out, err := New(srv.URL+"/", nil).Activate(context.Background(), &Pipeline{ID: "abc", Type: KindHelm})
+	r.NoError(err)
+	r.Equal("abc", out.ID)
+	r.Equal("s3cr3t", out.Secret)
+}
+
+func TestClient_ActivateIDMismatch(t *testing.T) {
+	r := require.New(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		json.NewEncoder(w).Encode(&ActivateResponse{ID: "other"})
+	}))
+	defer srv.Close()
+
+	_, err := New(srv.URL, nil).Activate(context.Background(), &Pipeline{ID: "abc"})
+	r.Error(err)
+}
+
+func TestClient_Get(t *testing.T) {
+	r := require.New(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.Equal(http.MethodGet, req.Method)
+		r.Equal("/api/pipelines/abc", req.URL.Path)
+		r.Equal("s3cr3t", req.Header.Get(SecretHeader))
+		json.NewEncoder(w).Encode(&Pipeline{ID: "abc", Type: KindHelm, Release: "web"})
+	}))
+	defer srv.Close()
+
+	out, err := New(srv.URL, nil).Get(context.Background(), Ref{ID: "abc", Secret: "s3cr3t"})
+	r.NoError(err)
+	r.Equal("web", out.Release)
+}
+
+func TestClient_Error(t *testing.T) {
+	r := require.New(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("wrong secret"))
+	}))
+	defer srv.Close()
+
+	err := New(srv.URL, nil).Deactivate(context.Background(), Ref{ID: "abc"})
+	r.Error(err)
+	apiErr, ok := err.(*Error)
+	r.True(ok)
+	r.Equal(http.StatusForbidden, apiErr.StatusCode)
+	r.Equal("wrong secret", apiErr.Body)
+}
+
+func TestClient_GetNotFound(t *testing.T) {
+	r := require.New(t)
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	_, err := New(srv.URL, nil).Get(context.Background(), Ref{ID: "abc"})
+	r.True(IsNotFound(err))
+}
+
+func TestClient_Credentials(t *testing.T) {
+	r := require.New(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/api/pipelines" {
+			r.Equal("Bearer t0ken", req.Header.Get("Authorization"))
+			w.Write([]byte("[]"))
+			return
+		}
+		user, pass, ok := req.BasicAuth()
+		r.True(ok)
+		r.Equal("user", user)
+		r.Equal("pass", pass)
+		w.Write([]byte(`{"id":"abc"}`))
+	}))
+	defer srv.Close()
+
+	cli := New(srv.URL, nil)
+	cli.Credentials = Credentials{Token: "t0ken", Username: "ignored"}
+	_, err := cli.List(context.Background())
+	r.NoError(err)
+
+	cli.Credentials = Credentials{Username: "user", Password: "pass"}
+	_, err = cli.Get(context.Background(), Ref{ID: "abc"})
+	r.NoError(err)
+}
+
+func TestClient_Retry(t *testing.T) {
+	r := require.New(t)
+	attempts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		attempts++
+		switch attempts {
+		case 1:
+			w.WriteHeader(http.StatusBadGateway)
+		case 2:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			var in Pipeline
+			r.NoError(json.NewDecoder(req.Body).Decode(&in))
+			json.NewEncoder(w).Encode(&ActivateResponse{ID: in.ID})
+		}
+	}))
+	defer srv.Close()
+
+	cli := New(srv.URL, nil)
+	cli.MaxRetries = 2
+	cli.minBackoff = time.Millisecond
+	_, err := cli.Activate(context.Background(), &Pipeline{ID: "abc"})
+	r.NoError(err)
+	r.Equal(3, attempts)
+
+	attempts = 0
+	cli.MaxRetries = 0
+	_, err = cli.Activate(context.Background(), &Pipeline{ID: "abc"})
+	r.Equal(http.StatusBadGateway, err.(*Error).StatusCode)
+	r.Equal(1, attempts)
+}
@@ -0,0 +1,83 @@
+// Copyright 2017-2021 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package pipelinesapi
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// shouldRetry reports whether failed request could be repeated.
+// Transport errors are retried only for idempotent requests, as request
+// could have been processed by the server, while 429 and 5xx responses
+// mean that the server has not completed the request
+func (c *Client) shouldRetry(ctx context.Context, method string, resp *http.Response, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		return method == http.MethodGet || method == http.MethodHead
+	}
+	return resp.StatusCode == http.StatusTooManyRequests ||
+		(resp.StatusCode >= 500 && resp.StatusCode != http.StatusNotImplemented)
+}
+
+// backoff returns delay before the next attempt: Retry-After header
+// of the response if present, exponential backoff with full jitter otherwise
+func (c *Client) backoff(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if wait, ok := retryAfter(resp.Header.Get("Retry-After")); ok {
+			if wait > c.maxBackoff {
+				return c.maxBackoff
+			}
+			return wait
+		}
+	}
+	wait := c.minBackoff << uint(attempt)
+	if wait <= 0 || wait > c.maxBackoff {
+		wait = c.maxBackoff
+	}
+	return time.Duration(rand.Int63n(int64(wait) + 1))
+}
+
+// retryAfter parses Retry-After header value (seconds or HTTP date)
+func retryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		wait := time.Until(at)
+		if wait < 0 {
+			wait = 0
+		}
+		return wait, true
+	}
+	return 0, false
+}
+
+func describe(resp *http.Response, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return fmt.Sprintf("status %d", resp.StatusCode)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
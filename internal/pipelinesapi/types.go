@@ -0,0 +1,72 @@
+// Copyright 2017-2021 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package pipelinesapi
+
+// Kind embeds type of the pipeline
+type Kind string
+
+const (
+	// KindHelm is HELM pipeline
+	KindHelm Kind = "helm"
+	// KindTerraform is Terraform pipeline
+	KindTerraform Kind = "terraform"
+	// KindScript is Script pipeline
+	KindScript Kind = "script"
+)
+
+// Ref is a secure reference to the pipeline
+// (requests without secret matching will not work)
+type Ref struct {
+	ID     string `json:"id"`
+	Secret string `json:"secret"`
+}
+
+// ActivateResponse is a response to pipeline activation
+type ActivateResponse struct {
+	ID     string `json:"id"`
+	Secret string `json:"secret"`
+}
+
+// Approvals are settings of manual approval of the pipeline
+type Approvals struct {
+	// Number of approves required for the pipeline
+	ApprovalsRequired int `json:"approvesrequired"`
+	// List of approvers who can approve pipeline
+	Approvers []string `json:"approvers"`
+}
+
+// Pipeline is a structure for pipeline creation, update and retrieval
+type Pipeline struct {
+	ID string `json:"id"`
+	// Secret (required for updates)
+	Secret string `json:"secret"`
+	// Kind of the pipeline
+	Type Kind `json:"type"`
+	// GIT origin to be checked
+	Origin string `json:"origin,omitempty"`
+	// GIT branches to be checked
+	Branches []string `json:"branches,omitempty"`
+	// Container registry for docker images
+	RegistryURL string `json:"registry_url,omitempty"`
+	// Container registrry provider
+	RegistryProvider string `json:"registry_provider,omitempty"`
+	// HELM: Chart ZIP archive location on S3 Bucket,
+	// TERRAFORM: plan archive location
+	Archive string `json:"archive,omitempty"`
+	// only for HELM: release name. should be Taken from chart.yaml if not speficied
+	Release string `json:"release,omitempty"`
+	// Chart release namespace. If not specified, 'default' will be used
+	Namespace string `json:"namespace,omitempty"`
+	// only for TERRAFORM: values for terraform plan and apply
+	Values map[string]string `json:"values,omitempty"`
+	// only for SCRIPT: command for execution
+	Exec string `json:"exec,omitempty"`
+	// only for SCRIPT: command to preview before the execution
+	Plan string `json:"plan,omitempty"`
+	// only for SCRIPT: environment variables for execution
+	Env map[string]string `json:"env,omitempty"`
+	// Approval settings are common for all kinds of pipelines
+	Approvals
+}
@@ -0,0 +1,135 @@
+// Copyright 2017-2021 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// MetaHeaderPrefix is a prefix of HTTP headers carrying object metadata
+const MetaHeaderPrefix = "X-Meta-"
+
+type httpStorage struct {
+	root    string
+	headers map[string]string
+	http    *http.Client
+}
+
+// NewHTTP creates storage on HTTP server supporting PUT, HEAD, GET and DELETE
+// of the files under root URL. Headers are added to every request (i.e. for authorization)
+func NewHTTP(root string, headers map[string]string, httpClient *http.Client) Storage {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &httpStorage{
+		root:    strings.TrimSuffix(root, "/"),
+		headers: headers,
+		http:    httpClient,
+	}
+}
+
+func (s *httpStorage) do(ctx context.Context, method, key string, body io.Reader, setup func(*http.Request)) (*http.Response, error) {
+	req, err := http.NewRequest(method, s.root+"/"+key, body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	for k, v := range s.headers {
+		req.Header.Set(k, v)
+	}
+	if setup != nil {
+		setup(req)
+	}
+	resp, err := s.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode == http.StatusPreconditionFailed {
+		resp.Body.Close()
+		return nil, ErrPreconditionFailed
+	}
+	if resp.StatusCode >= 300 {
+		buf, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s %s responded with status %d (%s)",
+			method, key, resp.StatusCode, string(buf))
+	}
+	return resp, nil
+}
+
+func (s *httpStorage) Put(ctx context.Context, obj *Object, body io.ReadSeeker) error {
+	n, err := size(body)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, http.MethodPut, obj.Key, body, func(req *http.Request) {
+		req.ContentLength = n
+		req.Header.Set("Content-Type", obj.ContentType)
+		for k, v := range obj.Metadata {
+			req.Header.Set(MetaHeaderPrefix+k, v)
+		}
+		if obj.Conditional && obj.ETag == "" {
+			req.Header.Set("If-None-Match", "*")
+		} else if obj.Conditional {
+			req.Header.Set("If-Match", obj.ETag)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (s *httpStorage) Head(ctx context.Context, key string) (*Object, error) {
+	resp, err := s.do(ctx, http.MethodHead, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return httpObject(key, resp), nil
+}
+
+func (s *httpStorage) Get(ctx context.Context, key string) (io.ReadCloser, *Object, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp.Body, httpObject(key, resp), nil
+}
+
+func (s *httpStorage) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, nil)
+	if err == ErrNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func httpObject(key string, resp *http.Response) *Object {
+	obj := &Object{
+		Key:         key,
+		ContentType: resp.Header.Get("Content-Type"),
+		Size:        resp.ContentLength,
+		Metadata:    map[string]string{},
+		ETag:        resp.Header.Get("ETag"),
+	}
+	for k := range resp.Header {
+		if strings.HasPrefix(k, MetaHeaderPrefix) {
+			obj.Metadata[strings.ToLower(strings.TrimPrefix(k, MetaHeaderPrefix))] = resp.Header.Get(k)
+		}
+	}
+	return obj
+}
@@ -0,0 +1,259 @@
+// Copyright 2017-2021 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// metaSuffix is added to the file name of object metadata
+const metaSuffix = ".meta.json"
+
+// lockSuffix is added to the file name of the object lock
+const lockSuffix = ".lock"
+
+// lockRetry is the delay between attempts to take a busy lock
+const lockRetry = 50 * time.Millisecond
+
+// lockStale is the age of a lock file considered left by a crashed process
+const lockStale = time.Minute
+
+type localStorage struct {
+	root string
+}
+
+// NewLocal creates storage in the local folder.
+// Metadata of each object is kept next to it in JSON file
+func NewLocal(root string) Storage {
+	return &localStorage{root: root}
+}
+
+func (s *localStorage) path(key string) (string, error) {
+	p := filepath.Join(s.root, filepath.FromSlash(key))
+	if !strings.HasPrefix(p, filepath.Clean(s.root)+string(filepath.Separator)) {
+		return "", fmt.Errorf("key %s is outside of %s", key, s.root)
+	}
+	return p, nil
+}
+
+// Put, Head, Get and Delete take the lock of the object, shared with other processes
+// (i.e. parallel terraform runs), so content and metadata are changed and read together
+func (s *localStorage) Put(ctx context.Context, obj *Object, body io.ReadSeeker) error {
+	p, err := s.path(obj.Key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	unlock, err := lockFile(ctx, p)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if obj.Conditional {
+		current, err := s.head(obj.Key, p)
+		if err == ErrNotFound {
+			current = &Object{}
+		} else if err != nil {
+			return err
+		}
+		if current.ETag != obj.ETag {
+			return ErrPreconditionFailed
+		}
+	}
+
+	n, err := writeFile(p, body)
+	if err != nil {
+		return err
+	}
+	meta := *obj
+	meta.Size = n
+	meta.ETag = ""
+	meta.Conditional = false
+	buf, err := json.Marshal(&meta)
+	if err != nil {
+		return err
+	}
+	_, err = writeFile(p+metaSuffix, bytes.NewReader(buf))
+	return err
+}
+
+// writeFile writes to temporary file first and renames it, so readers never see partial content
+func writeFile(p string, body io.Reader) (int64, error) {
+	f, err := ioutil.TempFile(filepath.Dir(p), filepath.Base(p)+".tmp")
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(f, body)
+	if errClose := f.Close(); err == nil {
+		err = errClose
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), p)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return 0, err
+	}
+	return n, nil
+}
+
+func (s *localStorage) Head(ctx context.Context, key string) (*Object, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	unlock, err := lockFile(ctx, p)
+	if os.IsNotExist(err) {
+		// no folder for the lock
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return s.head(key, p)
+}
+
+// head reads object information, the lock must be taken
+func (s *localStorage) head(key, p string) (*Object, error) {
+	info, err := os.Stat(p)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	obj := &Object{}
+	buf, err := ioutil.ReadFile(p + metaSuffix)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	} else if err == nil {
+		if err := json.Unmarshal(buf, obj); err != nil {
+			return nil, fmt.Errorf("%s%s parse failure %v", p, metaSuffix, err)
+		}
+	}
+	obj.Key = key
+	obj.Size = info.Size()
+	if obj.ETag, err = fileDigest(p); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (s *localStorage) Get(ctx context.Context, key string) (io.ReadCloser, *Object, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	unlock, err := lockFile(ctx, p)
+	if os.IsNotExist(err) {
+		return nil, nil, ErrNotFound
+	} else if err != nil {
+		return nil, nil, err
+	}
+	defer unlock()
+	obj, err := s.head(key, p)
+	if err != nil {
+		return nil, nil, err
+	}
+	// opened file keeps its content, even if it is replaced after the lock is released
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, obj, nil
+}
+
+func (s *localStorage) Delete(ctx context.Context, key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	unlock, err := lockFile(ctx, p)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer unlock()
+	for _, file := range []string{p, p + metaSuffix} {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// lockFile takes the lock of the object shared with other processes:
+// lock file next to it, created exclusively.
+// Returns the function releasing the lock
+func lockFile(ctx context.Context, p string) (func(), error) {
+	lock := p + lockSuffix
+	for {
+		f, err := os.OpenFile(lock, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(lock) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if info, err := os.Stat(lock); err == nil && time.Since(info.ModTime()) > lockStale {
+			breakLock(lock, info)
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%s is locked: %v", p, ctx.Err())
+		case <-time.After(lockRetry):
+		}
+	}
+}
+
+// breakLock removes the lock left by crashed process. Other waiters may break it
+// and take a new lock meanwhile, so the lock is renamed aside atomically and removed
+// only if it is still the stale one (inode may be reused, so ModTime is compared too),
+// otherwise it is put back
+func breakLock(lock string, stale os.FileInfo) {
+	aside := fmt.Sprintf("%s.%d.%d", lock, os.Getpid(), time.Now().UnixNano())
+	if err := os.Rename(lock, aside); err != nil {
+		// broken by another waiter
+		return
+	}
+	defer os.Remove(aside)
+	if info, err := os.Stat(aside); err == nil && os.SameFile(info, stale) && info.ModTime().Equal(stale.ModTime()) {
+		log.Printf("[WARN] removed stale lock %s", lock)
+		return
+	}
+	// link fails rather than replaces the lock taken after the rename
+	if err := os.Link(aside, lock); err != nil {
+		log.Printf("[WARN] lock %s taken concurrently, %v", lock, err)
+	}
+}
+
+// fileDigest is ETag of the local file: sha256 of its content
+func fileDigest(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
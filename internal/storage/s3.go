@@ -0,0 +1,300 @@
+// Copyright 2017-2021 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package storage
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// S3Options configure uploads to S3, applied to every object put to the bucket.
+// Zero values stand for S3 and s3manager defaults
+type S3Options struct {
+	// PartSize is size of a part in bytes (5MB at least),
+	// larger objects are uploaded in parts
+	PartSize int64
+	// Concurrency is number of parts uploaded in parallel
+	Concurrency int
+	// KMSKeyID enables server-side encryption with the KMS key (SSE-KMS)
+	KMSKeyID string
+	// Tags are added to the objects
+	Tags map[string]string
+	// ACL is canned ACL of the objects, i.e. bucket-owner-full-control
+	ACL string
+	// StorageClass of the objects, i.e. STANDARD_IA
+	StorageClass string
+}
+
+type s3Storage struct {
+	cli      *s3.S3
+	uploader *s3manager.Uploader
+	bucket   string
+	options  S3Options
+}
+
+// NewS3 creates storage in AWS S3 bucket
+func NewS3(sess *session.Session, bucket string, options S3Options) Storage {
+	cli := s3.New(sess)
+	uploader := s3manager.NewUploaderWithClient(cli, func(u *s3manager.Uploader) {
+		if options.PartSize > 0 {
+			u.PartSize = options.PartSize
+		}
+		if options.Concurrency > 0 {
+			u.Concurrency = options.Concurrency
+		}
+	})
+	return &s3Storage{cli: cli, uploader: uploader, bucket: bucket, options: options}
+}
+
+// optional returns nil for empty value, so it is not sent to S3
+func optional(v string) *string {
+	if v == "" {
+		return nil
+	}
+	return aws.String(v)
+}
+
+// encryption is server-side encryption algorithm, aws:kms when KMS key is configured
+func (s *s3Storage) encryption() *string {
+	if s.options.KMSKeyID == "" {
+		return nil
+	}
+	return aws.String(s3.ServerSideEncryptionAwsKms)
+}
+
+// tagging is URL-encoded tags of the object
+func (s *s3Storage) tagging() *string {
+	tags := url.Values{}
+	for k, v := range s.options.Tags {
+		tags.Set(k, v)
+	}
+	return optional(tags.Encode())
+}
+
+func (s *s3Storage) Put(ctx context.Context, obj *Object, body io.ReadSeeker) error {
+	n, err := size(body)
+	if err != nil {
+		return err
+	}
+	// conditional headers are not supported by multipart uploads
+	if obj.Conditional || n <= s.uploader.PartSize {
+		return s.putObject(ctx, obj, body)
+	}
+	partSize := s.uploader.PartSize
+	if n/partSize >= int64(s.uploader.MaxUploadParts) {
+		// s3manager enlarges parts the same way
+		partSize = n/int64(s.uploader.MaxUploadParts) + 1
+	}
+	log.Printf("[DEBUG] s3: multipart upload of %s (%d bytes, %d bytes parts)", obj.Key, n, partSize)
+	_, err = s.uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+		ACL:                  optional(s.options.ACL),
+		Body:                 body,
+		Bucket:               aws.String(s.bucket),
+		ContentType:          aws.String(obj.ContentType),
+		Key:                  aws.String(obj.Key),
+		Metadata:             aws.StringMap(obj.Metadata),
+		SSEKMSKeyId:          optional(s.options.KMSKeyID),
+		ServerSideEncryption: s.encryption(),
+		StorageClass:         optional(s.options.StorageClass),
+		Tagging:              s.tagging(),
+	})
+	if err != nil {
+		return s3Error(err)
+	}
+	// parts are checked by S3 with their Content-MD5 (set by SDK), the ETag of the object
+	// confirms they are assembled in order
+	stored, err := s.cli.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(obj.Key),
+	})
+	if err != nil {
+		return s3Error(err)
+	}
+	if !checksumETag(stored.ServerSideEncryption, stored.SSECustomerAlgorithm) {
+		return nil
+	}
+	expected, err := multipartETag(body, partSize)
+	if err != nil {
+		return err
+	}
+	return s.verifyETag(obj.Key, aws.StringValue(stored.ETag), expected)
+}
+
+// putObject uploads body with a single request
+func (s *s3Storage) putObject(ctx context.Context, obj *Object, body io.ReadSeeker) error {
+	out, err := s.cli.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		ACL:                  optional(s.options.ACL),
+		Body:                 body,
+		Bucket:               aws.String(s.bucket),
+		ContentType:          aws.String(obj.ContentType),
+		Key:                  aws.String(obj.Key),
+		Metadata:             aws.StringMap(obj.Metadata),
+		SSEKMSKeyId:          optional(s.options.KMSKeyID),
+		ServerSideEncryption: s.encryption(),
+		StorageClass:         optional(s.options.StorageClass),
+		Tagging:              s.tagging(),
+	}, precondition(obj))
+	if err != nil {
+		return s3Error(err)
+	}
+	if !checksumETag(out.ServerSideEncryption, out.SSECustomerAlgorithm) {
+		return nil
+	}
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	sum, err := md5Sum(body, -1)
+	if err != nil {
+		return err
+	}
+	return s.verifyETag(obj.Key, aws.StringValue(out.ETag), hex.EncodeToString(sum))
+}
+
+// md5Sum is checksum of the body (or its next n bytes, when n >= 0)
+func md5Sum(body io.Reader, n int64) ([]byte, error) {
+	if n >= 0 {
+		body = io.LimitReader(body, n)
+	}
+	h := md5.New()
+	if _, err := io.Copy(h, body); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// multipartETag is ETag S3 computes for the body uploaded in parts:
+// md5 of concatenated md5 of parts, followed by number of parts
+func multipartETag(body io.ReadSeeker, partSize int64) (string, error) {
+	n, err := size(body)
+	if err != nil {
+		return "", err
+	}
+	var sums []byte
+	parts := 0
+	for read := int64(0); read < n; read += partSize {
+		sum, err := md5Sum(body, partSize)
+		if err != nil {
+			return "", err
+		}
+		sums = append(sums, sum...)
+		parts++
+	}
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	total := md5.Sum(sums)
+	return fmt.Sprintf("%s-%d", hex.EncodeToString(total[:]), parts), nil
+}
+
+// checksumETag reports whether ETag of the stored object is md5 checksum of its content,
+// which is not the case for objects encrypted with KMS key (including bucket default
+// encryption) or customer-provided key, as S3 reports them
+func checksumETag(encryption, customerAlgorithm *string) bool {
+	return aws.StringValue(encryption) != s3.ServerSideEncryptionAwsKms && aws.StringValue(customerAlgorithm) == ""
+}
+
+// verifyETag compares ETag of the stored object with checksum of the uploaded content
+func (s *s3Storage) verifyETag(key, etag, expected string) error {
+	if etag = strings.Trim(etag, `"`); etag != expected {
+		return fmt.Errorf("%s is stored with ETag %s, uploaded content has %s", key, etag, expected)
+	}
+	return nil
+}
+
+// precondition adds conditional headers (not modelled in SDK) to the request
+func precondition(obj *Object) request.Option {
+	return func(r *request.Request) {
+		if !obj.Conditional {
+			return
+		}
+		if obj.ETag == "" {
+			r.HTTPRequest.Header.Set("If-None-Match", "*")
+		} else {
+			r.HTTPRequest.Header.Set("If-Match", obj.ETag)
+		}
+	}
+}
+
+func (s *s3Storage) Head(ctx context.Context, key string) (*Object, error) {
+	out, err := s.cli.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, s3Error(err)
+	}
+	return &Object{
+		Key:         key,
+		ContentType: aws.StringValue(out.ContentType),
+		Size:        aws.Int64Value(out.ContentLength),
+		Metadata:    s3Metadata(out.Metadata),
+		ETag:        aws.StringValue(out.ETag),
+	}, nil
+}
+
+func (s *s3Storage) Get(ctx context.Context, key string) (io.ReadCloser, *Object, error) {
+	out, err := s.cli.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, nil, s3Error(err)
+	}
+	return out.Body, &Object{
+		Key:         key,
+		ContentType: aws.StringValue(out.ContentType),
+		Size:        aws.Int64Value(out.ContentLength),
+		Metadata:    s3Metadata(out.Metadata),
+		ETag:        aws.StringValue(out.ETag),
+	}, nil
+}
+
+func (s *s3Storage) Delete(ctx context.Context, key string) error {
+	_, err := s.cli.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
+// s3Metadata normalizes metadata keys, as S3 returns them capitalized
+func s3Metadata(in map[string]*string) map[string]string {
+	res := map[string]string{}
+	for k, v := range in {
+		res[strings.ToLower(k)] = aws.StringValue(v)
+	}
+	return res
+}
+
+func s3Error(err error) error {
+	// failures of parts are wrapped by s3manager
+	if failure, ok := err.(s3manager.MultiUploadFailure); ok && failure.OrigErr() != nil {
+		err = failure.OrigErr()
+	}
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case s3.ErrCodeNoSuchKey, "NotFound":
+			return ErrNotFound
+		case "BadDigest", "InvalidDigest":
+			return fmt.Errorf("uploaded content doesn't match its checksum (%s)", aerr.Message())
+		case "PreconditionFailed", "ConditionalRequestConflict":
+			return ErrPreconditionFailed
+		}
+	}
+	return err
+}
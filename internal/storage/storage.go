@@ -0,0 +1,54 @@
+// Copyright 2017-2021 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+// Package storage abstracts location where chart archives are published
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// ErrNotFound is returned when there is no object under the key
+var ErrNotFound = errors.New("object not found")
+
+// ErrPreconditionFailed is returned by conditional Put,
+// when object was modified concurrently
+var ErrPreconditionFailed = errors.New("object was modified concurrently")
+
+// Object describes stored artifact
+type Object struct {
+	Key         string
+	ContentType string
+	Size        int64
+	Metadata    map[string]string
+	// ETag identifies version of the object, returned by Head and Get
+	ETag string
+	// Conditional makes Put succeed only if stored object still has ETag
+	// (or is still absent for empty ETag), for optimistic concurrency
+	Conditional bool
+}
+
+// Storage of chart archives
+type Storage interface {
+	// Put uploads body under obj.Key with its content type and metadata.
+	// Storage computing checksum of the content (S3 ETag) verifies it matches the body
+	Put(ctx context.Context, obj *Object, body io.ReadSeeker) error
+	// Head returns object information without its content
+	Head(ctx context.Context, key string) (*Object, error)
+	// Get returns object content, caller must close it
+	Get(ctx context.Context, key string) (io.ReadCloser, *Object, error)
+	// Delete removes object. Removal of missing object is not an error
+	Delete(ctx context.Context, key string) error
+}
+
+func size(body io.Seeker) (int64, error) {
+	n, err := body.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	_, err = body.Seek(0, io.SeekStart)
+	return n, err
+}
@@ -0,0 +1,389 @@
+// Copyright 2017-2021 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/stretchr/testify/require"
+)
+
+func testStorage(t *testing.T, s Storage) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	_, err := s.Head(ctx, "helm/chart-1.zip")
+	r.Equal(ErrNotFound, err)
+
+	r.NoError(s.Put(ctx, &Object{
+		Key:         "helm/chart-1.zip",
+		ContentType: "application/zip",
+		Metadata:    map[string]string{"chart-hash": "abc"},
+	}, bytes.NewReader([]byte("zip"))))
+
+	obj, err := s.Head(ctx, "helm/chart-1.zip")
+	r.NoError(err)
+	r.Equal(int64(3), obj.Size)
+	r.Equal("abc", obj.Metadata["chart-hash"])
+
+	body, _, err := s.Get(ctx, "helm/chart-1.zip")
+	r.NoError(err)
+	buf, err := ioutil.ReadAll(body)
+	body.Close()
+	r.NoError(err)
+	r.Equal("zip", string(buf))
+
+	r.NoError(s.Delete(ctx, "helm/chart-1.zip"))
+	r.NoError(s.Delete(ctx, "helm/chart-1.zip"))
+	_, _, err = s.Get(ctx, "helm/chart-1.zip")
+	r.Equal(ErrNotFound, err)
+}
+
+func TestLocal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storage")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	testStorage(t, NewLocal(dir))
+}
+
+func TestLocalConditional(t *testing.T) {
+	r := require.New(t)
+	dir, err := ioutil.TempDir("", "storage")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+	s := NewLocal(dir)
+	ctx := context.Background()
+
+	// concurrent creations of the same object: only one succeeds
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- s.Put(ctx, &Object{Key: "index.yaml", Conditional: true}, bytes.NewReader([]byte("index")))
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	succeeded := 0
+	for err := range errs {
+		if err == nil {
+			succeeded++
+		} else {
+			r.Equal(ErrPreconditionFailed, err)
+		}
+	}
+	r.Equal(1, succeeded)
+
+	// lock taken by another process blocks the write
+	obj, err := s.Head(ctx, "index.yaml")
+	r.NoError(err)
+	lock := filepath.Join(dir, "index.yaml"+lockSuffix)
+	r.NoError(ioutil.WriteFile(lock, nil, 0644))
+	cancelled, cancel := context.WithTimeout(ctx, 3*lockRetry)
+	defer cancel()
+	err = s.Put(cancelled, &Object{Key: "index.yaml", ETag: obj.ETag, Conditional: true}, bytes.NewReader([]byte("index-2")))
+	r.Error(err)
+	r.Contains(err.Error(), "is locked")
+
+	// lock left by crashed process is removed
+	old := time.Now().Add(-2 * lockStale)
+	r.NoError(os.Chtimes(lock, old, old))
+	r.NoError(s.Put(ctx, &Object{Key: "index.yaml", ETag: obj.ETag, Conditional: true}, bytes.NewReader([]byte("index-2"))))
+	_, err = os.Stat(lock)
+	r.True(os.IsNotExist(err))
+
+	// stale lock already replaced by fresh one is kept
+	r.NoError(ioutil.WriteFile(lock, nil, 0644))
+	r.NoError(os.Chtimes(lock, old, old))
+	stale, err := os.Stat(lock)
+	r.NoError(err)
+	r.NoError(os.Remove(lock))
+	r.NoError(ioutil.WriteFile(lock, []byte("fresh"), 0644))
+	breakLock(lock, stale)
+	buf, err := ioutil.ReadFile(lock)
+	r.NoError(err)
+	r.Equal("fresh", string(buf))
+	matches, err := filepath.Glob(lock + ".*")
+	r.NoError(err)
+	r.Empty(matches)
+}
+
+func TestHTTP(t *testing.T) {
+	var mu sync.Mutex
+	files := map[string][]byte{}
+	headers := map[string]http.Header{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if req.Header.Get("Authorization") != "token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch req.Method {
+		case http.MethodPut:
+			files[req.URL.Path], _ = ioutil.ReadAll(req.Body)
+			headers[req.URL.Path] = req.Header.Clone()
+		case http.MethodDelete:
+			delete(files, req.URL.Path)
+		default:
+			buf, ok := files[req.URL.Path]
+			if !ok {
+				http.NotFound(w, req)
+				return
+			}
+			for k, v := range headers[req.URL.Path] {
+				w.Header()[k] = v
+			}
+			w.Write(buf)
+		}
+	}))
+	defer srv.Close()
+
+	testStorage(t, NewHTTP(srv.URL+"/charts", map[string]string{"Authorization": "token"}, nil))
+}
+
+// s3Server is a stand-in of S3 computing ETags like S3 does
+type s3Server struct {
+	*httptest.Server
+
+	mu sync.Mutex
+	// etags of the stored objects by path
+	etags map[string]string
+	// parts are sizes of multipart uploads by path
+	parts map[string]int
+	// partSums are md5 of the uploaded parts by path and part number
+	partSums map[string]map[string][]byte
+	// puts are Content-MD5 of single request uploads by path
+	puts map[string]string
+	// partMD5 are Content-MD5 of the uploaded parts by path
+	partMD5 map[string][]string
+	// options are upload option headers by path
+	options map[string][]string
+	// encryption is server-side encryption of the stored objects by path
+	encryption map[string]string
+	// defaultKMS encrypts objects with KMS key by default, like bucket default encryption
+	defaultKMS bool
+	// corrupt damages received content, as if it was corrupted in transfer
+	corrupt bool
+	// badETag makes stored ETags differ from the received content
+	badETag bool
+}
+
+func newS3Server(t *testing.T) *s3Server {
+	s := &s3Server{
+		etags:      map[string]string{},
+		parts:      map[string]int{},
+		partSums:   map[string]map[string][]byte{},
+		puts:       map[string]string{},
+		partMD5:    map[string][]string{},
+		options:    map[string][]string{},
+		encryption: map[string]string{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		body, _ := ioutil.ReadAll(req.Body)
+		sum := md5.Sum(body)
+		if s.corrupt {
+			sum[0]++
+		}
+		if sent := req.Header.Get("Content-MD5"); sent != "" && sent != base64.StdEncoding.EncodeToString(sum[:]) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`<Error><Code>BadDigest</Code><Message>The Content-MD5 you specified did not match what we received.</Message></Error>`))
+			return
+		}
+		if s.badETag {
+			sum[0]++
+		}
+		q := req.URL.Query()
+		_, initiate := q["uploads"]
+		switch {
+		case req.Method == http.MethodPost && initiate:
+			s.recordOptions(req)
+			s.encrypt(req)
+			w.Write([]byte(`<InitiateMultipartUploadResult><UploadId>1</UploadId></InitiateMultipartUploadResult>`))
+		case req.Method == http.MethodPut && q.Get("partNumber") != "":
+			s.parts[req.URL.Path] += len(body)
+			s.partMD5[req.URL.Path] = append(s.partMD5[req.URL.Path], req.Header.Get("Content-MD5"))
+			if s.partSums[req.URL.Path] == nil {
+				s.partSums[req.URL.Path] = map[string][]byte{}
+			}
+			s.partSums[req.URL.Path][q.Get("partNumber")] = sum[:]
+			w.Header().Set("ETag", fmt.Sprintf(`"%x"`, sum))
+		case req.Method == http.MethodPost && q.Get("uploadId") != "":
+			var sums []byte
+			parts := s.partSums[req.URL.Path]
+			for i := 1; i <= len(parts); i++ {
+				sums = append(sums, parts[strconv.Itoa(i)]...)
+			}
+			delete(s.partSums, req.URL.Path)
+			s.etags[req.URL.Path] = fmt.Sprintf(`"%x-%d"`, md5.Sum(sums), len(parts))
+			w.Header().Set("X-Amz-Server-Side-Encryption", s.encryption[req.URL.Path])
+			w.Write([]byte(`<CompleteMultipartUploadResult><ETag>` + s.etags[req.URL.Path] + `</ETag></CompleteMultipartUploadResult>`))
+		case req.Method == http.MethodPut:
+			s.puts[req.URL.Path] = req.Header.Get("Content-MD5")
+			s.etags[req.URL.Path] = fmt.Sprintf(`"%x"`, sum)
+			s.recordOptions(req)
+			s.encrypt(req)
+			w.Header().Set("ETag", s.etags[req.URL.Path])
+			w.Header().Set("X-Amz-Server-Side-Encryption", s.encryption[req.URL.Path])
+		case req.Method == http.MethodHead:
+			etag, ok := s.etags[req.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("ETag", etag)
+			w.Header().Set("X-Amz-Server-Side-Encryption", s.encryption[req.URL.Path])
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *s3Server) recordOptions(req *http.Request) {
+	for _, h := range []string{
+		"X-Amz-Acl",
+		"X-Amz-Server-Side-Encryption",
+		"X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id",
+		"X-Amz-Storage-Class",
+		"X-Amz-Tagging",
+	} {
+		s.options[req.URL.Path] = append(s.options[req.URL.Path], req.Header.Get(h))
+	}
+}
+
+// encrypt records encryption of the object, requested or bucket default one
+func (s *s3Server) encrypt(req *http.Request) {
+	encryption := req.Header.Get("X-Amz-Server-Side-Encryption")
+	if encryption == "" && s.defaultKMS {
+		encryption = "aws:kms"
+	}
+	s.encryption[req.URL.Path] = encryption
+}
+
+// storage creates S3 storage using the server
+func (s *s3Server) storage(t *testing.T, options S3Options) Storage {
+	sess, err := session.NewSession(&aws.Config{
+		Endpoint:         aws.String(s.URL),
+		Region:           aws.String("eu-central-1"),
+		S3ForcePathStyle: aws.Bool(true),
+		Credentials:      credentials.NewStaticCredentials("key", "secret", ""),
+	})
+	require.NoError(t, err)
+	return NewS3(sess, "charts", options)
+}
+
+// TestS3Put checks that large objects are uploaded in parts,
+// while small ones are put with a single request, both with upload options
+func TestS3Put(t *testing.T) {
+	r := require.New(t)
+	srv := newS3Server(t)
+	s := srv.storage(t, S3Options{
+		PartSize:     5 * 1024 * 1024,
+		Concurrency:  2,
+		KMSKeyID:     "alias/charts",
+		Tags:         map[string]string{"team": "web", "cost center": "42"},
+		ACL:          "bucket-owner-full-control",
+		StorageClass: "STANDARD_IA",
+	})
+	ctx := context.Background()
+
+	large := bytes.Repeat([]byte("x"), 11*1024*1024)
+	r.NoError(s.Put(ctx, &Object{Key: "helm/large.tgz"}, bytes.NewReader(large)))
+	r.Equal(map[string]int{"/charts/helm/large.tgz": len(large)}, srv.parts)
+
+	r.NoError(s.Put(ctx, &Object{Key: "helm/small.tgz"}, bytes.NewReader([]byte("tgz"))))
+	r.Equal(map[string]string{"/charts/helm/small.tgz": "BdEZDYHjfQZgfMGeIfY+pw=="}, srv.puts)
+
+	expected := []string{"bucket-owner-full-control", "aws:kms", "alias/charts", "STANDARD_IA", "cost+center=42&team=web"}
+	r.Equal(map[string][]string{
+		"/charts/helm/large.tgz": expected,
+		"/charts/helm/small.tgz": expected,
+	}, srv.options)
+}
+
+// TestS3Verify checks that ETag S3 computes for the stored object
+// is compared with checksum of the uploaded content
+func TestS3Verify(t *testing.T) {
+	r := require.New(t)
+	srv := newS3Server(t)
+	s := srv.storage(t, S3Options{PartSize: 5 * 1024 * 1024})
+	ctx := context.Background()
+	large := bytes.Repeat([]byte("x"), 11*1024*1024)
+
+	r.NoError(s.Put(ctx, &Object{Key: "helm/small.tgz"}, bytes.NewReader([]byte("tgz"))))
+	r.NoError(s.Put(ctx, &Object{Key: "helm/large.tgz"}, bytes.NewReader(large)))
+	r.Equal(`"78572fc7bc540a4aee035305387f5b72-3"`, srv.etags["/charts/helm/large.tgz"])
+
+	srv.badETag = true
+	err := s.Put(ctx, &Object{Key: "helm/small.tgz"}, bytes.NewReader([]byte("tgz")))
+	r.Error(err)
+	r.Contains(err.Error(), "helm/small.tgz is stored with ETag")
+	err = s.Put(ctx, &Object{Key: "helm/large.tgz"}, bytes.NewReader(large))
+	r.Error(err)
+	r.Contains(err.Error(), "helm/large.tgz is stored with ETag")
+
+	// ETag of objects encrypted with KMS key is not their checksum
+	kms := srv.storage(t, S3Options{PartSize: 5 * 1024 * 1024, KMSKeyID: "alias/charts"})
+	r.NoError(kms.Put(ctx, &Object{Key: "helm/small.tgz"}, bytes.NewReader([]byte("tgz"))))
+	r.NoError(kms.Put(ctx, &Object{Key: "helm/large.tgz"}, bytes.NewReader(large)))
+
+	// as well as of objects encrypted by bucket default KMS key
+	srv.defaultKMS = true
+	r.NoError(s.Put(ctx, &Object{Key: "helm/small.tgz"}, bytes.NewReader([]byte("tgz"))))
+	r.NoError(s.Put(ctx, &Object{Key: "helm/large.tgz"}, bytes.NewReader(large)))
+
+	// or with customer-provided key
+	r.False(checksumETag(nil, aws.String("AES256")))
+	r.True(checksumETag(aws.String("AES256"), nil))
+}
+
+// TestS3MultipartIntegrity checks that every part is sent with its checksum,
+// so S3 rejects corrupted parts, while assembly is verified by the ETag
+func TestS3MultipartIntegrity(t *testing.T) {
+	r := require.New(t)
+	srv := newS3Server(t)
+	s := srv.storage(t, S3Options{PartSize: 5 * 1024 * 1024})
+	ctx := context.Background()
+
+	large := make([]byte, 11*1024*1024)
+	for i := range large {
+		large[i] = byte(i % 251)
+	}
+	r.NoError(s.Put(ctx, &Object{Key: "helm/large.tgz"}, bytes.NewReader(large)))
+	sums := srv.partMD5["/charts/helm/large.tgz"]
+	r.Len(sums, 3)
+	for _, sum := range sums {
+		r.NotEmpty(sum)
+	}
+
+	// corrupted part is rejected by S3 comparing it with Content-MD5
+	srv.corrupt = true
+	err := s.Put(ctx, &Object{Key: "helm/large.tgz"}, bytes.NewReader(large))
+	r.Error(err)
+	r.Contains(err.Error(), "doesn't match its checksum")
+}